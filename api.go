@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// API serves the HTTP control interface for a session:
+//
+//	GET  /status            monitor state, last trigger, disk usage
+//	POST /arm, /disarm      resume or pause lid triggers
+//	POST /record?seconds=N  record now, deliver, and describe the result
+//	GET  /events            recent events; a live stream with
+//	                        Accept: text/event-stream
+//	GET  /recordings        saved recordings, newest first
+//	GET  /recordings/{id}   one recording's files and metadata; ?download=1
+//	                        returns the video
+//	GET  /live.mjpg         live preview, shared with pre-roll and recordings
+//
+// Browsers can't add headers to an <img> request, so for GET /live.mjpg
+// only the token may also be given as ?token=.
+type API struct {
+	Session *Session
+	Token   string
+	Logf    func(format string, args ...any)
+
+	stop <-chan struct{}
+}
+
+// startAPI serves the API on config.APIListen, if set, until stop is closed.
+func startAPI(s *Session, stop <-chan struct{}, logf func(format string, args ...any)) {
+	cfg := currentConfig()
+	if cfg.APIListen == "" {
+		return
+	}
+	if cfg.APIToken == "" {
+		logf("API not started: api_listen is set but api_token is empty")
+		return
+	}
+	ln, err := apiListener(cfg.APIListen)
+	if err != nil {
+		logf("API not started: %v", err)
+		return
+	}
+
+	a := &API{Session: s, Token: cfg.APIToken, Logf: logf, stop: stop}
+	srv := &http.Server{Handler: a.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logf("API: %v", err)
+		}
+	}()
+	go func() {
+		<-stop
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+	logf("API listening on %s", cfg.APIListen)
+}
+
+// apiListener listens on "unix:<path>" or a loopback host:port; the API is
+// not meant to be reachable from other machines.
+func apiListener(addr string) (net.Listener, error) {
+	if sock, ok := strings.CutPrefix(addr, "unix:"); ok {
+		// A socket left behind by a crash would make Listen fail.
+		if info, err := os.Lstat(sock); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(sock)
+		}
+		ln, err := net.Listen("unix", sock)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(sock, 0o600); err != nil {
+			ln.Close()
+			return nil, err
+		}
+		return ln, nil
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("api_listen %q: %w", addr, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("api_listen %q: only localhost, 127.0.0.1, ::1 or a unix: socket are allowed", addr)
+	}
+	return net.Listen("tcp", addr)
+}
+
+func (a *API) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", a.status)
+	mux.HandleFunc("POST /arm", a.arm)
+	mux.HandleFunc("POST /disarm", a.disarm)
+	mux.HandleFunc("POST /record", a.record)
+	mux.HandleFunc("GET /events", a.events)
+	mux.HandleFunc("GET /recordings", a.recordings)
+	mux.HandleFunc("GET /recordings/{id...}", a.recording)
+	mux.HandleFunc("GET /live.mjpg", a.live)
+	return a.authorize(mux)
+}
+
+func (a *API) authorize(next http.Handler) http.Handler {
+	want := []byte("Bearer " + a.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		// Tokens in URLs end up in logs and browser history; keep that to
+		// the one route a browser can't send a header for.
+		if token := r.URL.Query().Get("token"); token != "" && r.Method == http.MethodGet && r.URL.Path == "/live.mjpg" {
+			got = []byte("Bearer " + token)
+		}
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			a.Logf("API: rejected %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("missing or wrong bearer token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// apiStatus is SessionStatus for JSON.
+type apiStatus struct {
+	Camera         string     `json:"camera"`
+	State          string     `json:"state"`
+	Paused         bool       `json:"paused"`
+	Recording      bool       `json:"recording"`
+	LastTrigger    *time.Time `json:"last_trigger,omitempty"`
+	LastRecording  string     `json:"last_recording,omitempty"`
+	VideoFiles     int        `json:"video_files"`
+	VideoBytes     int64      `json:"video_bytes"`
+	FreeBytes      uint64     `json:"free_bytes,omitempty"`
+	PendingUploads int        `json:"pending_uploads"`
+}
+
+func (a *API) currentStatus() apiStatus {
+	st := a.Session.Status()
+	out := apiStatus{
+		Camera:         a.Session.Device.Label(),
+		State:          st.State.String(),
+		Paused:         st.Paused,
+		Recording:      st.Busy,
+		VideoFiles:     st.VideoFiles,
+		VideoBytes:     st.VideoBytes,
+		FreeBytes:      st.FreeBytes,
+		PendingUploads: st.Pending,
+	}
+	if !st.LastTrigger.IsZero() {
+		out.LastTrigger = &st.LastTrigger
+	}
+	if st.LastPath != "" {
+		out.LastRecording = recordingID(st.LastPath)
+	}
+	return out
+}
+
+func (a *API) status(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.currentStatus())
+}
+
+func (a *API) arm(w http.ResponseWriter, r *http.Request) {
+	a.Session.Monitor.SetPaused(false)
+	a.Logf("API: armed")
+	writeJSON(w, http.StatusOK, a.currentStatus())
+}
+
+func (a *API) disarm(w http.ResponseWriter, r *http.Request) {
+	a.Session.Monitor.SetPaused(true)
+	a.Logf("API: disarmed")
+	writeJSON(w, http.StatusOK, a.currentStatus())
+}
+
+// record works like the bot's /clip: the recording is delivered to the
+// notifiers, and the response waits until it is saved.
+func (a *API) record(w http.ResponseWriter, r *http.Request) {
+	s := a.Session
+	dur := s.Duration
+	if v := r.URL.Query().Get("seconds"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid seconds %q", v))
+			return
+		}
+		dur = time.Duration(n) * time.Second
+	}
+	if dur > maxClip {
+		dur = maxClip
+	}
+
+	a.Logf("API: recording %v", dur)
+	done := make(chan Recording, 1)
+	err := s.Go(func() {
+		rec := s.Record(dur, TriggerAPI)
+		defer rec.Release()
+		done <- rec
+		if rec.Err == nil && len(activeNotifiers()) > 0 {
+			s.Deliver(rec)
+		}
+	})
+	rec := Recording{Err: err}
+	if err == nil {
+		rec = <-done
+	}
+
+	switch {
+	case errors.Is(rec.Err, ErrBusy):
+		writeError(w, http.StatusConflict, rec.Err)
+	case errors.Is(rec.Err, ErrShuttingDown):
+		writeError(w, http.StatusServiceUnavailable, rec.Err)
+	case rec.Err != nil:
+		writeError(w, http.StatusInternalServerError, rec.Err)
+	default:
+		info, err := findRecording(recordingID(rec.Path))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, info)
+	}
+}
+
+// events lists the recent events, or streams new ones as server-sent events
+// until the client goes away or the API stops.
+func (a *API) events(w http.ResponseWriter, r *http.Request) {
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		writeJSON(w, http.StatusOK, a.Session.Events.History())
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming not supported"))
+		return
+	}
+
+	events, cancel := a.Session.Events.Subscribe()
+	defer cancel()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// Comments keep proxies and idle timeouts from closing the stream.
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-a.stop:
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case ev := <-events:
+			data, _ := json.Marshal(ev)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Kind, data)
+		}
+		flusher.Flush()
+	}
+}
+
+// RecordingInfo describes a saved recording. Files are relative to the
+// videos directory.
+type RecordingInfo struct {
+	ID       string   `json:"id"`
+	Time     string   `json:"time"`
+	Size     int64    `json:"size"`
+	Video    string   `json:"video,omitempty"`
+	Files    []string `json:"files"`
+	Metadata *Sidecar `json:"metadata,omitempty"`
+}
+
+// recordingID is the API's ID for the recording that path belongs to.
+func recordingID(path string) string {
+	dir, err := videosDir()
+	if err != nil {
+		return filepath.Base(path)
+	}
+	return recordingKey(dir, path)
+}
+
+// recordingInfo describes r, with its sidecar if withMetadata is set.
+func recordingInfo(dir string, r *savedRecording, withMetadata bool) RecordingInfo {
+	info := RecordingInfo{ID: r.key, Time: r.time.Format(time.RFC3339), Size: r.size, Files: []string{}}
+	for _, f := range r.files {
+		rel, _ := filepath.Rel(dir, f)
+		info.Files = append(info.Files, filepath.ToSlash(rel))
+		if info.Video == "" && isVideoFile(f) && !uploadCopy.MatchString(f) {
+			info.Video = filepath.ToSlash(rel)
+		}
+		if withMetadata && strings.HasSuffix(f, ".json") {
+			if data, err := os.ReadFile(f); err == nil {
+				var sc Sidecar
+				if json.Unmarshal(data, &sc) == nil {
+					info.Metadata = &sc
+				}
+			}
+		}
+	}
+	return info
+}
+
+var errNoRecording = errors.New("no such recording")
+
+func findRecording(id string) (RecordingInfo, error) {
+	if id == "" || path.Clean(id) != id || strings.HasPrefix(id, "../") || path.IsAbs(id) {
+		return RecordingInfo{}, errNoRecording
+	}
+	dir, err := videosDir()
+	if err != nil {
+		return RecordingInfo{}, err
+	}
+	recs, err := listRecordings(dir)
+	if err != nil {
+		return RecordingInfo{}, err
+	}
+	for _, r := range recs {
+		if r.key == id {
+			return recordingInfo(dir, r, true), nil
+		}
+	}
+	return RecordingInfo{}, errNoRecording
+}
+
+func (a *API) recordings(w http.ResponseWriter, r *http.Request) {
+	dir, err := videosDir()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	recs, err := listRecordings(dir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	list := []RecordingInfo{}
+	for _, rec := range recs {
+		list = append(list, recordingInfo(dir, rec, false))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (a *API) recording(w http.ResponseWriter, r *http.Request) {
+	info, err := findRecording(r.PathValue("id"))
+	if errors.Is(err, errNoRecording) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if r.URL.Query().Get("download") == "" {
+		writeJSON(w, http.StatusOK, info)
+		return
+	}
+
+	if info.Video == "" {
+		writeError(w, http.StatusNotFound, errors.New("recording has no video"))
+		return
+	}
+	dir, err := videosDir()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(info.Video)))
+	http.ServeFile(w, r, filepath.Join(dir, filepath.FromSlash(info.Video)))
+}
+
+// live streams the preview, opening the camera if nothing else has it
+// open. A recording that starts meanwhile takes the camera over and keeps
+// the stream fed.
+func (a *API) live(w http.ResponseWriter, r *http.Request) {
+	s := a.Session
+	if err := s.PreRoll.Watch(); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	defer s.PreRoll.Unwatch()
+	s.Live.Stream(w, r, a.stop)
+}
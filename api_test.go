@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const testToken = "s3cret"
+
+func testAPI(t *testing.T) (*API, *Session) {
+	t.Helper()
+	s := testSession(t)
+	return &API{Session: s, Token: testToken, Logf: t.Logf}, s
+}
+
+// call sends a request through the API handler with the test token, unless
+// auth overrides the Authorization header.
+func call(t *testing.T, a *API, method, target string, auth ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	for _, h := range auth {
+		req.Header.Set("Authorization", h)
+	}
+	w := httptest.NewRecorder()
+	a.Handler().ServeHTTP(w, req)
+	return w
+}
+
+func TestAPIRequiresToken(t *testing.T) {
+	a, _ := testAPI(t)
+	for _, auth := range []string{"", "Bearer wrong", "Bearer " + testToken + "x", testToken} {
+		w := call(t, a, "GET", "/status", auth)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: %d, want 401", auth, w.Code)
+		}
+		if w.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("Authorization %q: no WWW-Authenticate challenge", auth)
+		}
+	}
+	if w := call(t, a, "GET", "/status"); w.Code != http.StatusOK {
+		t.Errorf("with the token: %d, want 200", w.Code)
+	}
+}
+
+func TestAPIArmDisarm(t *testing.T) {
+	a, s := testAPI(t)
+	s.Monitor.Observe(false)
+
+	for _, tt := range []struct {
+		path   string
+		paused bool
+		state  string
+	}{
+		{"/disarm", true, "disarmed"},
+		{"/arm", false, "armed"},
+	} {
+		w := call(t, a, "POST", tt.path)
+		if w.Code != http.StatusOK {
+			t.Fatalf("POST %s: %d %s", tt.path, w.Code, w.Body)
+		}
+		var st apiStatus
+		if err := json.NewDecoder(w.Body).Decode(&st); err != nil {
+			t.Fatal(err)
+		}
+		if st.Paused != tt.paused || st.State != tt.state {
+			t.Errorf("POST %s: paused %v, state %s; want %v, %s", tt.path, st.Paused, st.State, tt.paused, tt.state)
+		}
+		if s.Monitor.Paused() != tt.paused {
+			t.Errorf("POST %s: monitor paused = %v", tt.path, s.Monitor.Paused())
+		}
+	}
+
+	if w := call(t, a, "GET", "/arm"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /arm: %d, want 405", w.Code)
+	}
+}
+
+func TestAPIRecordBusy(t *testing.T) {
+	a, s := testAPI(t)
+	if err := s.acquire("snap"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.release()
+
+	w := call(t, a, "POST", "/record?seconds=1")
+	if w.Code != http.StatusConflict {
+		t.Errorf("POST /record while busy: %d %s, want 409", w.Code, w.Body)
+	}
+}
+
+func TestAPIRecordBadSeconds(t *testing.T) {
+	a, _ := testAPI(t)
+	for _, v := range []string{"0", "-5", "soon"} {
+		if w := call(t, a, "POST", "/record?seconds="+v); w.Code != http.StatusBadRequest {
+			t.Errorf("seconds=%s: %d, want 400", v, w.Code)
+		}
+	}
+}
+
+func TestAPIRecordDuringShutdown(t *testing.T) {
+	a, s := testAPI(t)
+	if !s.Shutdown(time.Second) {
+		t.Fatal("Shutdown() timed out with nothing running")
+	}
+	w := call(t, a, "POST", "/record?seconds=1")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("POST /record after Shutdown: %d %s, want 503", w.Code, w.Body)
+	}
+	if err := s.Go(func() { t.Error("job ran after Shutdown") }); err != ErrShuttingDown {
+		t.Errorf("Go() after Shutdown = %v, want ErrShuttingDown", err)
+	}
+}
+
+func TestAPIQueryTokenOnlyForLive(t *testing.T) {
+	a, _ := testAPI(t)
+	for _, tt := range []struct {
+		method, target string
+	}{
+		{"GET", "/status?token=" + testToken},
+		{"POST", "/disarm?token=" + testToken},
+		{"POST", "/record?seconds=1&token=" + testToken},
+		{"GET", "/recordings?token=" + testToken},
+		{"GET", "/events?token=" + testToken},
+	} {
+		if w := call(t, a, tt.method, tt.target, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without a header: %d, want 401", tt.method, tt.target, w.Code)
+		}
+	}
+
+	if w := call(t, a, "GET", "/live.mjpg?token=wrong", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /live.mjpg with a wrong token: %d, want 401", w.Code)
+	}
+	// A stopped API ends the stream right after the headers.
+	stop := make(chan struct{})
+	close(stop)
+	a.stop = stop
+	if w := call(t, a, "GET", "/live.mjpg?token="+testToken, ""); w.Code != http.StatusOK {
+		t.Errorf("GET /live.mjpg with the query token: %d %s, want 200", w.Code, w.Body)
+	}
+}
@@ -2,39 +2,152 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"gocv.io/x/gocv"
 )
 
 type Device struct {
-	Id         int
+	Id int
+	// Source, if set, is opened instead of camera Id; see openSource.
+	Source     string
 	Width      float64
 	Height     float64
 	Resolution string
 	FPS        int
 }
 
+func (d Device) Label() string {
+	if d.Source != "" {
+		return fmt.Sprintf("%s - %s @ %dfps", d.Source, d.Resolution, d.FPS)
+	}
+	return fmt.Sprintf("Camera %d - %s @ %dfps", d.Id, d.Resolution, d.FPS)
+}
+
 type Config struct {
 	BotToken string `json:"bot_token"`
 	ChatID   int64  `json:"chat_id"`
+
+	// LidSensor selects the lid backend: "auto" (default), "ioreg", "acpi" or "logind".
+	LidSensor   string `json:"lid_sensor,omitempty"`
+	ACPILidRoot string `json:"acpi_lid_root,omitempty"`
+	DBusAddress string `json:"dbus_address,omitempty"`
+
+	// PreRollSeconds > 0 keeps the camera open while armed and prepends that
+	// many seconds to each recording, using at most PreRollMaxMB of memory.
+	PreRollSeconds int `json:"preroll_seconds,omitempty"`
+	PreRollMaxMB   int `json:"preroll_max_mb,omitempty"`
+
+	// SnapshotFrames is how many of the first frames are considered for the
+	// still sent ahead of the video (default 10).
+	SnapshotFrames int `json:"snapshot_frames,omitempty"`
+
+	// FaceModel enables face detection with a Haar cascade (.xml) or YuNet
+	// (.onnx) model, run on every FaceSampleEvery-th frame (default 5).
+	FaceModel       string `json:"face_model,omitempty"`
+	FaceSampleEvery int    `json:"face_sample_every,omitempty"`
+
+	// MotionMaxSeconds > 0 keeps recording past the requested length while
+	// there is motion, until MotionIdleSeconds (default 5) pass without any
+	// or the recording reaches MotionMaxSeconds. MotionThreshold is the
+	// fraction of pixels that must change (default 0.01).
+	MotionMaxSeconds  int     `json:"motion_max_seconds,omitempty"`
+	MotionIdleSeconds int     `json:"motion_idle_seconds,omitempty"`
+	MotionThreshold   float64 `json:"motion_threshold,omitempty"`
+
+	// Overlay stamps time, host name, trigger and frame number onto every
+	// frame. OverlayPosition is "top-left" (default), "top-right",
+	// "bottom-left" or "bottom-right"; OverlayScale defaults to 0.6.
+	Overlay         bool    `json:"overlay,omitempty"`
+	OverlayPosition string  `json:"overlay_position,omitempty"`
+	OverlayScale    float64 `json:"overlay_scale,omitempty"`
+
+	// EncryptPublicKey, an age public key from "iseeyougo keygen" or
+	// age-keygen, seals every video so that
+	// only the holder of the private key can watch it. Snapshots, face crops
+	// and sidecars are not encrypted.
+	EncryptPublicKey string `json:"encrypt_public_key,omitempty"`
+
+	// Retention removes the oldest recordings once any limit is exceeded;
+	// KeepUndelivered spares those still waiting in the upload queue.
+	// Recording refuses to start with less than MinFreeMB (default 100) free.
+	RetentionDays     int  `json:"retention_days,omitempty"`
+	RetentionMaxMB    int  `json:"retention_max_mb,omitempty"`
+	RetentionMaxCount int  `json:"retention_max_count,omitempty"`
+	KeepUndelivered   bool `json:"keep_undelivered,omitempty"`
+	MinFreeMB         int  `json:"min_free_mb,omitempty"`
+
+	// Codec and Container pick the video format (default avc1 in mp4).
+	// CodecFallback lists "codec" or "codec:container" entries to try in
+	// order if that doesn't open, e.g. ["mp4v:mp4", "MJPG:avi"].
+	Codec         string   `json:"codec,omitempty"`
+	Container     string   `json:"container,omitempty"`
+	CodecFallback []string `json:"codec_fallback,omitempty"`
+
+	// OutputDir replaces ~/iseeyougo/videos. FilenameTemplate names each
+	// recording, see expandTemplate; it defaults to
+	// "capture_{time:20060102_150405}".
+	OutputDir        string `json:"output_dir,omitempty"`
+	FilenameTemplate string `json:"filename_template,omitempty"`
+
+	// Sources are extra camera sources (device paths, video files, image
+	// directories, test patterns) listed next to the detected cameras.
+	Sources []string `json:"sources,omitempty"`
+
+	// Notifiers receive every recording, in addition to the bot_token/chat_id
+	// Telegram chat above.
+	Notifiers []NotifierConfig `json:"notifiers,omitempty"`
+
+	// APIListen starts the HTTP API on a loopback address such as
+	// "127.0.0.1:8765", or on a Unix socket as "unix:/path/to/socket".
+	// Requests must carry "Authorization: Bearer <APIToken>".
+	APIListen string `json:"api_listen,omitempty"`
+	APIToken  string `json:"api_token,omitempty"`
 }
 
 var devices []Device
-var config Config
-var bot *tgbotapi.BotAPI
 
-func configPath() string {
+// config is replaced as a whole on reload while recordings, the janitor and
+// deliveries read it; use currentConfig, setConfig and updateConfig.
+var (
+	configMu sync.RWMutex
+	config   Config
+)
+
+// currentConfig returns a copy of the configuration to read from.
+func currentConfig() Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return config
+}
+
+func setConfig(cfg Config) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	config = cfg
+}
+
+// updateConfig changes the configuration in place, for command line
+// overrides and settings edited in the GUI.
+func updateConfig(edit func(cfg *Config)) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	edit(&config)
+}
+
+// sendTimeout bounds a single upload to a notifier.
+const sendTimeout = 2 * time.Minute
+
+func configDir() string {
 	base, err := os.UserConfigDir()
 	if err != nil {
 		home, _ := os.UserHomeDir()
@@ -42,19 +155,74 @@ func configPath() string {
 	}
 	dir := filepath.Join(base, "iseeyougo")
 	os.MkdirAll(dir, 0o755) // ensure directory exists
-	return filepath.Join(dir, "config.json")
+	return dir
+}
+
+func configPath() string {
+	return filepath.Join(configDir(), "config.json")
+}
+
+// openOutbox loads the retry queue into the global outbox. Without it
+// failed uploads are only logged.
+func openOutbox(logf func(format string, args ...any), onChange func(pending int)) {
+	o, err := OpenOutbox(outboxPath())
+	if err != nil {
+		logf("Cannot open upload queue: %v", err)
+		return
+	}
+	o.Logf = logf
+	o.OnChange = onChange
+	outbox = o
+	if n := o.Len(); n > 0 {
+		logf("Upload queue: %d pending", n)
+	}
+	go o.Run(nil, findNotifier)
+}
+
+// readConfig reads the config file without side effects; a missing file is
+// an empty Config.
+func readConfig(path string) (Config, error) {
+	cfg, err := readConfigFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Config{}, nil
+	}
+	return cfg, err
+}
+
+// readConfigFile is readConfig for when the file has to be there.
+func readConfigFile(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("read %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// validate catches settings that would otherwise fail or weaken every
+// recording.
+func (c Config) validate() error {
+	if c.EncryptPublicKey != "" {
+		if _, err := ParsePublicKey(c.EncryptPublicKey); err != nil {
+			return fmt.Errorf("encrypt_public_key: %w", err)
+		}
+	}
+	return nil
 }
 
 func loadConfig() {
 	path := configPath()
-	fmt.Println("Loading Telegram configuration from", path)
+	fmt.Println("Loading configuration from", path)
 
 	file, err := os.Open(path)
 	if err != nil {
 		fmt.Println("Config not found, creating example at", path)
 
 		example := Config{
-			BotToken: "PUT_YOUR_BOT_TOKEN_HERE",
+			BotToken: placeholderBotToken,
 			ChatID:   0,
 		}
 		_ = os.MkdirAll(filepath.Dir(path), 0o755)
@@ -71,82 +239,31 @@ func loadConfig() {
 	}
 	defer file.Close()
 
-	if err := json.NewDecoder(file).Decode(&config); err != nil {
+	var cfg Config
+	if err := json.NewDecoder(file).Decode(&cfg); err != nil {
 		fmt.Println("Error reading", path, ":", err)
 		return
 	}
-
-	if config.BotToken == "PUT_YOUR_BOT_TOKEN_HERE" || config.ChatID == 0 {
-		fmt.Println("Please edit", path, "with your bot token and chat ID")
-		return
-	}
-
-	var botErr error
-	bot, botErr = tgbotapi.NewBotAPI(config.BotToken)
-	if botErr != nil {
-		fmt.Printf("Telegram bot error: %v\n", botErr)
-		return
-	}
-	fmt.Printf("Telegram bot connected (@%s)\n", bot.Self.UserName)
+	setConfig(cfg)
+	initNotifiers()
 }
 
-func sendVideo(videoPath string) {
-	if bot == nil {
-		fmt.Println("Telegram bot not configured, video saved locally.")
-		return
-	}
-
-	fileInfo, err := os.Stat(videoPath)
-	if err != nil {
-		log.Printf("Cannot access video file: %v", err)
-	}
+// initNotifiers replaces the active notifiers with those in config.
+func initNotifiers() {
+	path := configPath()
 
-	fileSizeMB := float64(fileInfo.Size()) / (1024 * 1024)
-	if fileSizeMB > 50 {
-		log.Printf("Video too large for Telegram (%.1f MB > 50MB", fileSizeMB)
-		return
+	ns, errs := newNotifiers(currentConfig())
+	setNotifiers(ns)
+	for _, err := range errs {
+		fmt.Printf("Notifier error: %v\n", err)
 	}
-
-	fmt.Println("Sending video to Telegram...")
-
-	video := tgbotapi.NewVideo(config.ChatID, tgbotapi.FilePath(videoPath))
-	video.Caption = fmt.Sprintf("Laptop lid opened - %s", time.Now().Format("Jan 2, 15:04:05"))
-
-	_, botErr := bot.Send(video)
-
-	if botErr != nil {
-		log.Printf("Failed to send video to Telegram: %v", botErr)
-
-		msg := tgbotapi.NewMessage(config.ChatID, fmt.Sprintf("Video recorded but failed to send (%.1f MB)\n%s", fileSizeMB, videoPath))
-
-		if _, msgErr := bot.Send(msg); msgErr != nil {
-			log.Printf("Failed to send notification message: %v", msgErr)
-		}
+	if len(ns) == 0 && len(errs) == 0 {
+		fmt.Println("Please edit", path, "with your bot token and chat ID")
 		return
 	}
-	fmt.Println("Video sent successfully")
-
-}
-
-// true=open, false=closed
-func checkLidStatus() (bool, error) {
-	cmd := exec.Command("ioreg", "-r", "-k", "AppleClamshellState", "-d", "1")
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	if err := cmd.Run(); err != nil {
-		return false, fmt.Errorf("failed to run ioreg: %w", err)
-	}
-	for _, line := range strings.Split(out.String(), "\n") {
-		if strings.Contains(line, "AppleClamshellState") {
-			if strings.Contains(line, "= Yes") {
-				return false, nil
-			}
-			if strings.Contains(line, "= No") {
-				return true, nil
-			}
-		}
+	for _, n := range ns {
+		fmt.Printf("Notifier %s ready\n", n.Name())
 	}
-	return false, fmt.Errorf("AppleClamshellState not found")
 }
 
 func enumerate(max int) {
@@ -172,12 +289,33 @@ func enumerate(max int) {
 	}
 }
 
+// enumerateSources probes the configured non-index sources and adds the ones
+// that open to devices.
+func enumerateSources(sources []string) {
+	for _, source := range sources {
+		src, w, h, fps, err := openSource(Device{Id: -1, Source: source})
+		if err != nil {
+			log.Printf("Skipping source %s: %v", source, err)
+			continue
+		}
+		src.Close()
+		devices = append(devices, Device{
+			Id:         -1,
+			Source:     source,
+			Width:      float64(w),
+			Height:     float64(h),
+			Resolution: fmt.Sprintf("%dx%d", w, h),
+			FPS:        fps,
+		})
+	}
+}
+
 func chooseDevice() (Device, error) {
 	reader := bufio.NewReader(os.Stdin)
 
 	fmt.Println("Available cameras:")
 	for idx, d := range devices {
-		fmt.Printf("  [%d] id=%d  %s @ %dfps\n", idx, d.Id, d.Resolution, d.FPS)
+		fmt.Printf("  [%d] %s\n", idx, d.Label())
 	}
 	fmt.Print("Pick camera: ")
 	line, _ := reader.ReadString('\n')
@@ -189,115 +327,101 @@ func chooseDevice() (Device, error) {
 	return devices[n], nil
 }
 
-func monitor(dev Device, dur time.Duration) {
-	log.Println("Monitoring lid state... (Ctrl+C to quit)")
-	ticker := time.NewTicker(500 * time.Millisecond)
-	defer ticker.Stop()
-
-	armed := false
-	var prev bool
-	havePrev := false
-	lastTrigger := time.Time{}
-	const cooldown = 5 * time.Second
+// monitor records on every trigger until SIGINT or SIGTERM, then shuts down
+// gracefully; see shutdown.
+func monitor(sensor LidSensor, dev Device, dur time.Duration, opts monitorOptions) {
+	log.Println(withQueue("Monitoring lid state... (Ctrl+C to quit)"))
 
-	for range ticker.C {
-		open, err := checkLidStatus()
-		if err != nil {
-			continue
-		}
-		if !havePrev {
-			prev = open
-			havePrev = true
-		}
-		if !open {
-			armed = true
-		}
-		if armed && !prev && open {
-			if time.Since(lastTrigger) > cooldown {
-				lastTrigger = time.Now()
-				armed = false
-				go func() {
-					takeVideo(dev, dur)
-				}()
+	s := NewSession(dev, dur, sensor)
+	s.Motion = motionGateFromConfig(currentConfig())
+	s.Logf = func(format string, args ...any) {
+		fmt.Printf(format+"\n", args...)
+	}
+	if opts.Drain <= 0 {
+		opts.Drain = defaultDrainTimeout
+	}
+	stop := make(chan struct{})
+	handleSignals(s, opts, stop)
+	go s.Monitor.Run(stop)
+	startBotCommands(s, stop, log.Printf)
+	startAPI(s, stop, log.Printf)
+	startJanitor(stop, log.Printf)
+
+	paused := false
+	for ev := range s.Monitor.Events() {
+		switch ev.Kind {
+		case EventState:
+			if paused && !ev.Paused {
+				log.Println(withQueue("Monitoring re-armed"))
+			}
+			paused = ev.Paused
+
+			switch {
+			case ev.Paused:
+				log.Println(withQueue("Monitoring disarmed"))
+				s.PreRoll.Stop()
+			case ev.State == Armed:
+				log.Println(withQueue("Lid closed - recording armed"))
+				if err := s.PreRoll.Start(); err != nil {
+					log.Printf("Pre-roll: %v", err)
+				}
+			case ev.State == Disarmed:
+				s.PreRoll.Stop()
+			}
+		case EventTrigger:
+			if err := s.Go(func() { takeVideo(s) }); err != nil {
+				log.Printf("Lid opened, not recording: %v", err)
 			}
+		case EventCooldownRejected:
+			log.Println("Lid opened but still in cooldown period")
 		}
-		prev = open
 	}
+	shutdown(s, opts.Drain)
 }
 
-// takeVideo records for 'dur' and saves timestamped MP4
-func takeVideo(d Device, dur time.Duration) {
+// takeVideo records for the session's duration and saves timestamped MP4
+func takeVideo(s *Session) {
 	fmt.Println("Lid opened, recording…")
 
-	cap, err := gocv.OpenVideoCapture(d.Id)
-	if err != nil || !cap.IsOpened() {
-		log.Printf("open cam %d: %w", d.Id, err)
+	rec := s.Record(0, TriggerLid)
+	defer rec.Release()
+	if rec.Err != nil {
+		log.Printf("Recording failed: %v", rec.Err)
 		return
 	}
-	defer cap.Close()
 
-	w, h := int(d.Width), int(d.Height)
-	if w == 0 || h == 0 {
-		w, h = 1280, 720
+	fmt.Printf("Saved: %s (%d frames, %d dropped, %.1f fps)\n", rec.Path, rec.Frames, rec.Dropped, rec.FPS)
+	if rec.Snapshot != "" {
+		fmt.Println("Snapshot:", rec.Snapshot)
 	}
-	cap.Set(gocv.VideoCaptureFrameWidth, float64(w))
-	cap.Set(gocv.VideoCaptureFrameHeight, float64(h))
-	fps := d.FPS
-	if fps <= 0 {
-		fps = 30
-	}
-
-	home, err := os.UserHomeDir()
-	if err != nil {
-		fmt.Sprintf("Error finding home dir: %v\n", err)
-		return
-	}
-
-	ts := time.Now().Format("20060102_150405")
-	dir := filepath.Join(home, "iseeyougo", "videos")
-	_ = os.MkdirAll(dir, 0o755)
-
-	filename := filepath.Join(dir, fmt.Sprintf("capture_%s.mp4", ts))
 
-	writer, err := gocv.VideoWriterFile(filename, "avc1", float64(fps), w, h, true)
-	if err != nil {
-		log.Printf("create writer: %w", err)
+	if len(activeNotifiers()) == 0 {
+		fmt.Println("No notifiers configured, video saved locally.")
 		return
 	}
+	fmt.Println("Sending video...")
+	s.Deliver(rec)
+}
 
-	img := gocv.NewMat()
-	defer img.Close()
-
-	deadline := time.Now().Add(dur)
-	tick := time.NewTicker(time.Second / time.Duration(fps))
-	defer tick.Stop()
-
-	fmt.Printf("Recording %dx%d @ %dfps: %s\n", w, h, fps, filename)
-
-	for range tick.C {
-		if time.Now().After(deadline) {
-			break
-		}
-		if ok := cap.Read(&img); !ok || img.Empty() {
-			continue
-		}
-		if err := writer.Write(img); err != nil {
-			fmt.Printf("Write: %w", err)
-			continue
-		}
-	}
-
-	writer.Close()
-	time.Sleep(1 * time.Second)
-
-	fmt.Println("Saved:", filename)
-	sendVideo(filename)
+// prompt prints a question and returns the trimmed answer, empty for the
+// default.
+func prompt(r *bufio.Reader, format string, args ...any) string {
+	fmt.Printf(format, args...)
+	line, _ := r.ReadString('\n')
+	return strings.TrimSpace(line)
 }
 
 func runCLI() {
 	loadConfig()
+	if err := currentConfig().validate(); err != nil {
+		log.Fatalf("%s: %v", configPath(), err)
+	}
+	openOutbox(log.Printf, func(pending int) {
+		log.Printf("Upload queue: %d pending", pending)
+	})
 
 	enumerate(3)
+	enumerateSources(currentConfig().Sources)
 	if len(devices) == 0 {
 		log.Fatal("No cameras found")
 	}
@@ -306,18 +430,46 @@ func runCLI() {
 		log.Fatal(err)
 	}
 
-	fmt.Print("Length in seconds (default 15): ")
-
 	r := bufio.NewReader(os.Stdin)
-	line, _ := r.ReadString('\n')
-	line = strings.TrimSpace(line)
-
 	dur := 15 // default
-	if line != "" {
-		if n, err := strconv.Atoi(line); err == nil && n > 0 {
-			dur = n
+	if n, err := strconv.Atoi(prompt(r, "Length in seconds, the minimum if motion extends it (default %d): ", dur)); err == nil && n > 0 {
+		dur = n
+	}
+
+	motion := currentConfig()
+	if n, err := strconv.Atoi(prompt(r, "Keep recording while there is motion, up to how many seconds? (default %d, 0 = off): ", motion.MotionMaxSeconds)); err == nil && n >= 0 {
+		motion.MotionMaxSeconds = n
+	}
+	if motion.MotionMaxSeconds > 0 {
+		idle := motion.MotionIdleSeconds
+		if idle <= 0 {
+			idle = defaultMotionIdle
+		}
+		if n, err := strconv.Atoi(prompt(r, "Stop after how many seconds without motion? (default %d): ", idle)); err == nil && n > 0 {
+			motion.MotionIdleSeconds = n
 		}
+		threshold := motion.MotionThreshold
+		if threshold <= 0 {
+			threshold = defaultMotionThreshold
+		}
+		if v, err := strconv.ParseFloat(prompt(r, "Fraction of the picture that must change to count as motion? (default %g): ", threshold), 64); err == nil && v > 0 && v <= 1 {
+			motion.MotionThreshold = v
+		}
+	}
+	useMotion := func(cfg *Config) {
+		cfg.MotionMaxSeconds = motion.MotionMaxSeconds
+		cfg.MotionIdleSeconds = motion.MotionIdleSeconds
+		cfg.MotionThreshold = motion.MotionThreshold
+	}
+	updateConfig(useMotion)
+
+	sensor, err := newLidSensor(currentConfig())
+	if err != nil {
+		log.Fatal(err)
 	}
+	defer closeLidSensor(sensor)
 
-	monitor(dev, time.Duration(dur)*time.Second)
+	monitor(sensor, dev, time.Duration(dur)*time.Second, monitorOptions{
+		Overrides: useMotion,
+	})
 }
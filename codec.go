@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+// VideoFormat is a FourCC codec in a container, e.g. avc1 in mp4.
+type VideoFormat struct {
+	Codec     string
+	Container string
+}
+
+func (f VideoFormat) String() string { return f.Codec + ":" + f.Container }
+
+// Ext is the file extension for the container, with the dot.
+func (f VideoFormat) Ext() string { return "." + f.Container }
+
+var videoContainers = map[string]bool{"mp4": true, "avi": true, "mkv": true, "webm": true}
+
+// Not every OpenCV build has an H.264 encoder; mp4v and MJPG almost always
+// work.
+var defaultVideoFormats = []VideoFormat{{"avc1", "mp4"}, {"mp4v", "mp4"}, {"MJPG", "avi"}}
+
+// defaultContainer is where each codec goes if only the codec is given.
+func defaultContainer(codec string) string {
+	switch strings.ToUpper(codec) {
+	case "MJPG", "XVID":
+		return "avi"
+	case "VP80", "VP90":
+		return "webm"
+	}
+	return "mp4"
+}
+
+// parseVideoFormat accepts "codec" or "codec:container".
+func parseVideoFormat(s string) (VideoFormat, error) {
+	codec, container, _ := strings.Cut(strings.TrimSpace(s), ":")
+	if len(codec) != 4 {
+		return VideoFormat{}, fmt.Errorf("invalid codec %q: want a FourCC such as avc1, mp4v, MJPG, XVID or VP80", codec)
+	}
+	if container == "" {
+		container = defaultContainer(codec)
+	}
+	container = strings.ToLower(strings.TrimPrefix(container, "."))
+	if !videoContainers[container] {
+		return VideoFormat{}, fmt.Errorf("invalid container %q: want mp4, avi, mkv or webm", container)
+	}
+	return VideoFormat{codec, container}, nil
+}
+
+// videoFormats is the configured codec followed by the fallbacks, or the
+// defaults if nothing is configured. Invalid entries are logged and skipped.
+func videoFormats(cfg Config, logf func(format string, args ...any)) []VideoFormat {
+	var specs []string
+	if cfg.Codec != "" {
+		spec := cfg.Codec
+		if cfg.Container != "" {
+			spec += ":" + cfg.Container
+		}
+		specs = append(specs, spec)
+	}
+	specs = append(specs, cfg.CodecFallback...)
+
+	var formats []VideoFormat
+	seen := map[VideoFormat]bool{}
+	for _, s := range specs {
+		f, err := parseVideoFormat(s)
+		if err != nil {
+			logf("Ignoring codec setting: %v", err)
+			continue
+		}
+		if !seen[f] {
+			seen[f] = true
+			formats = append(formats, f)
+		}
+	}
+	if len(formats) == 0 {
+		return defaultVideoFormats
+	}
+	return formats
+}
+
+// openVideoWriter tries formats in order until a writer opens. name returns
+// the file to write for an extension, as reserveOutput does; it is removed
+// and released again if that format fails. The caller releases the file
+// that is returned.
+func openVideoWriter(formats []VideoFormat, name func(ext string) (string, func(), error), fps float64, w, h int, logf func(format string, args ...any)) (*gocv.VideoWriter, string, func(), VideoFormat, error) {
+	var errs []string
+	for _, f := range formats {
+		path, release, err := name(f.Ext())
+		if err != nil {
+			return nil, "", nil, f, err
+		}
+		writer, err := gocv.VideoWriterFile(path, f.Codec, fps, w, h, true)
+		if err == nil && writer.IsOpened() {
+			logf("Using codec %s", f)
+			return writer, path, release, f, nil
+		}
+		if err == nil {
+			writer.Close()
+			err = fmt.Errorf("not supported by this OpenCV build")
+		}
+		os.Remove(path)
+		release()
+		errs = append(errs, fmt.Sprintf("%s: %v", f, err))
+		logf("Codec %s failed, trying the next one: %v", f, err)
+	}
+	return nil, "", nil, VideoFormat{}, fmt.Errorf("create writer: no usable codec (%s)", strings.Join(errs, "; "))
+}
+
+// isVideoFile reports whether path is a recording in one of the supported
+// containers, encrypted or not.
+func isVideoFile(path string) bool {
+	ext := strings.TrimPrefix(filepath.Ext(strings.TrimSuffix(path, sealExt)), ".")
+	return videoContainers[strings.ToLower(ext)]
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+func TestParseVideoFormat(t *testing.T) {
+	tests := []struct {
+		in   string
+		want VideoFormat
+		err  string
+	}{
+		{"avc1", VideoFormat{"avc1", "mp4"}, ""},
+		{"mp4v", VideoFormat{"mp4v", "mp4"}, ""},
+		{"MJPG", VideoFormat{"MJPG", "avi"}, ""},
+		{"mjpg", VideoFormat{"mjpg", "avi"}, ""},
+		{"XVID", VideoFormat{"XVID", "avi"}, ""},
+		{"VP80", VideoFormat{"VP80", "webm"}, ""},
+		{"VP90", VideoFormat{"VP90", "webm"}, ""},
+		{"avc1:mkv", VideoFormat{"avc1", "mkv"}, ""},
+		{" MJPG:.AVI ", VideoFormat{"MJPG", "avi"}, ""},
+		{"MJPG:", VideoFormat{"MJPG", "avi"}, ""},
+		{"", VideoFormat{}, "invalid codec"},
+		{"h264", VideoFormat{"h264", "mp4"}, ""},
+		{"h265x", VideoFormat{}, "invalid codec"},
+		{"avc", VideoFormat{}, "invalid codec"},
+		{":mp4", VideoFormat{}, "invalid codec"},
+		{"avc1:mov", VideoFormat{}, "invalid container"},
+		{"avc1:mp4:x", VideoFormat{}, "invalid container"},
+	}
+	for _, tt := range tests {
+		got, err := parseVideoFormat(tt.in)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseVideoFormat(%q) = %v, %v; want error %q", tt.in, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseVideoFormat(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestVideoFormats(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    Config
+		want   []VideoFormat
+		logged int
+	}{
+		{"defaults", Config{}, defaultVideoFormats, 0},
+		{"codec only", Config{Codec: "mp4v"}, []VideoFormat{{"mp4v", "mp4"}}, 0},
+		{"codec and container", Config{Codec: "avc1", Container: "mkv"}, []VideoFormat{{"avc1", "mkv"}}, 0},
+		{
+			"fallbacks in order",
+			Config{Codec: "avc1", CodecFallback: []string{"MJPG:avi", "mp4v"}},
+			[]VideoFormat{{"avc1", "mp4"}, {"MJPG", "avi"}, {"mp4v", "mp4"}},
+			0,
+		},
+		{
+			"fallbacks without a codec",
+			Config{CodecFallback: []string{"XVID", "MJPG"}},
+			[]VideoFormat{{"XVID", "avi"}, {"MJPG", "avi"}},
+			0,
+		},
+		{
+			"duplicates keep the first",
+			Config{Codec: "MJPG", CodecFallback: []string{"mp4v", "MJPG:avi", "mp4v:mp4"}},
+			[]VideoFormat{{"MJPG", "avi"}, {"mp4v", "mp4"}},
+			0,
+		},
+		{
+			"invalid entries skipped",
+			Config{Codec: "nop", CodecFallback: []string{"mp4v:mov", "MJPG"}},
+			[]VideoFormat{{"MJPG", "avi"}},
+			2,
+		},
+		{"nothing valid", Config{Codec: "x", CodecFallback: []string{"y"}}, defaultVideoFormats, 2},
+	}
+	for _, tt := range tests {
+		var logged []string
+		logf := func(format string, args ...any) { logged = append(logged, format) }
+		got := videoFormats(tt.cfg, logf)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: videoFormats() = %v, want %v", tt.name, got, tt.want)
+		}
+		if len(logged) != tt.logged {
+			t.Errorf("%s: logged %d lines, want %d", tt.name, len(logged), tt.logged)
+		}
+	}
+}
+
+func TestOpenVideoWriterFallsBack(t *testing.T) {
+	dir := t.TempDir()
+	var reserved []string
+	name := func(ext string) (string, func(), error) {
+		path, release, err := reserveOutput(dir, "capture", NameFields{}, ext)
+		reserved = append(reserved, path)
+		return path, release, err
+	}
+	// No OpenCV build has a ZZZZ encoder; MJPG in avi always works.
+	formats := []VideoFormat{{"ZZZZ", "mkv"}, {"MJPG", "avi"}, {"mp4v", "mp4"}}
+	writer, path, release, format, err := openVideoWriter(formats, name, 10, 64, 48, t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if format != (VideoFormat{"MJPG", "avi"}) {
+		t.Errorf("format = %v, want MJPG:avi", format)
+	}
+	if want := filepath.Join(dir, "capture.avi"); path != want || len(reserved) != 2 {
+		t.Errorf("wrote %s after reserving %q, want %s", path, reserved, want)
+	}
+	if _, err := os.Stat(reserved[0]); !os.IsNotExist(err) {
+		t.Errorf("failed format's file %s left behind", reserved[0])
+	}
+
+	src, w, h, _, err := openSource(Device{Id: -1, Source: "test:pattern?size=64x48&fps=10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	if w != 64 || h != 48 {
+		t.Fatalf("test pattern is %dx%d", w, h)
+	}
+	img := gocv.NewMat()
+	defer img.Close()
+	for i := 0; i < 10; i++ {
+		if !src.Read(&img) {
+			t.Fatal("test pattern read failed")
+		}
+		if err := writer.Write(img); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writer.Close()
+
+	if n, err := frameCount(path); err != nil || n != 10 {
+		t.Errorf("%s has %d frames (%v), want 10", path, n, err)
+	}
+}
+
+func TestOpenVideoWriterNoCodec(t *testing.T) {
+	dir := t.TempDir()
+	name := func(ext string) (string, func(), error) {
+		return reserveOutput(dir, "capture", NameFields{}, ext)
+	}
+	_, _, _, _, err := openVideoWriter([]VideoFormat{{"ZZZZ", "mkv"}, {"YYYY", "avi"}}, name, 10, 64, 48, t.Logf)
+	if err == nil || !strings.Contains(err.Error(), "ZZZZ:mkv") || !strings.Contains(err.Error(), "YYYY:avi") {
+		t.Errorf("openVideoWriter() = %v, want an error naming both formats", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("files left behind: %v", entries)
+	}
+}
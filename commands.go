@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxClip bounds /clip so a typo can't record for an hour.
+const maxClip = 5 * time.Minute
+
+// botRetry is how often BotCommands tries to reach Telegram while offline.
+const botRetry = time.Minute
+
+// BotCommands answers commands sent to the Telegram bot. Only messages from
+// the notifier's own chat are accepted; anything else is rejected and logged.
+//
+//	/arm, /disarm   resume or pause lid triggers
+//	/status         monitor state, last trigger, disk usage
+//	/snap           take and send a photo now
+//	/clip [secs]    record now and send the clip
+//	/last           resend the last recording
+type BotCommands struct {
+	Notifier *TelegramNotifier
+	Session  *Session
+	Logf     func(format string, args ...any)
+}
+
+// startBotCommands serves commands on the first Telegram notifier, if any,
+// until stop is closed.
+func startBotCommands(s *Session, stop <-chan struct{}, logf func(format string, args ...any)) {
+	for _, n := range activeNotifiers() {
+		if t, ok := n.(*TelegramNotifier); ok {
+			c := &BotCommands{Notifier: t, Session: s, Logf: logf}
+			go c.Run(stop)
+			logf("Accepting bot commands from chat %d", t.chatID)
+			return
+		}
+	}
+}
+
+// Run long-polls for updates until stop is closed. While Telegram can't be
+// reached it tries again every botRetry.
+func (c *BotCommands) Run(stop <-chan struct{}) {
+	bot, err := c.Notifier.api()
+	for err != nil {
+		c.Logf("Bot commands unavailable, retrying in %v: %v", botRetry, err)
+		select {
+		case <-stop:
+			return
+		case <-time.After(botRetry):
+		}
+		bot, err = c.Notifier.api()
+	}
+
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = 60
+	updates := bot.GetUpdatesChan(u)
+	defer bot.StopReceivingUpdates()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			msg := update.Message
+			if msg == nil || !msg.IsCommand() {
+				continue
+			}
+			if msg.Chat == nil || msg.Chat.ID != c.Notifier.chatID {
+				from := ""
+				if msg.From != nil {
+					from = " (@" + msg.From.UserName + ")"
+				}
+				var chatID int64
+				if msg.Chat != nil {
+					chatID = msg.Chat.ID
+				}
+				c.Logf("Rejected /%s from chat %d%s", msg.Command(), chatID, from)
+				continue
+			}
+			// Recording takes a while; keep answering other commands meanwhile.
+			go c.handle(msg.Command(), msg.CommandArguments())
+		}
+	}
+}
+
+func (c *BotCommands) handle(cmd, args string) {
+	c.Logf("Bot command: /%s %s", cmd, args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), maxClip+sendTimeout)
+	defer cancel()
+
+	reply := func(text string) {
+		if _, err := c.Notifier.SendText(ctx, text); err != nil {
+			c.Logf("Bot reply failed: %v", err)
+		}
+	}
+
+	s := c.Session
+	switch cmd {
+	case "arm":
+		s.Monitor.SetPaused(false)
+		reply("Armed: the next lid close/open will be recorded.")
+
+	case "disarm":
+		s.Monitor.SetPaused(true)
+		reply("Disarmed: lid events are ignored until /arm.")
+
+	case "status":
+		reply(s.Status().String())
+
+	case "snap":
+		err := c.inSession(func() {
+			path, err := s.Snap()
+			if err != nil {
+				reply(fmt.Sprintf("Snapshot failed: %v", err))
+				return
+			}
+			caption := fmt.Sprintf("Snapshot - %s", time.Now().Format("Jan 2, 15:04:05"))
+			defer holdFile(path)()
+			if _, err := c.Notifier.SendPhoto(ctx, path, caption); err != nil {
+				c.Logf("Sending snapshot failed: %v", err)
+			}
+		})
+		if err != nil {
+			reply(fmt.Sprintf("Snapshot failed: %v", err))
+		}
+
+	case "clip":
+		dur := s.Duration
+		if args = strings.TrimSpace(args); args != "" {
+			n, err := strconv.Atoi(args)
+			if err != nil || n <= 0 {
+				reply("Usage: /clip [seconds]")
+				return
+			}
+			dur = time.Duration(n) * time.Second
+		}
+		if dur > maxClip {
+			dur = maxClip
+		}
+		err := c.inSession(func() {
+			reply(fmt.Sprintf("Recording %v...", dur))
+			rec := s.Record(dur, TriggerClip)
+			defer rec.Release()
+			if rec.Err != nil {
+				reply(fmt.Sprintf("Recording failed: %v", rec.Err))
+				return
+			}
+			d := c.sendVideo(ctx, rec.Path, fmt.Sprintf("Clip - %s", rec.Start.Format("Jan 2, 15:04:05")))
+			s.noteDeliveries(rec, "video", []Delivery{d})
+		})
+		if err != nil {
+			reply(fmt.Sprintf("Recording failed: %v", err))
+		}
+
+	case "last":
+		path, ok := s.LastRecording()
+		if !ok {
+			reply("No recordings yet.")
+			return
+		}
+		c.sendVideo(ctx, path, fmt.Sprintf("Last recording - %s", path))
+
+	case "start", "help":
+		reply("Commands: /arm /disarm /status /snap /clip [seconds] /last")
+
+	default:
+		reply(fmt.Sprintf("Unknown command /%s", cmd))
+	}
+}
+
+// inSession runs f as session work, so that Shutdown waits for the
+// recording and its delivery, and returns once f has. It refuses with
+// ErrShuttingDown once Shutdown has begun.
+func (c *BotCommands) inSession(f func()) error {
+	done := make(chan struct{})
+	err := c.Session.Go(func() {
+		defer close(done)
+		f()
+	})
+	if err != nil {
+		return err
+	}
+	<-done
+	return nil
+}
+
+// sendVideo returns the outcome of the last part sent.
+func (c *BotCommands) sendVideo(ctx context.Context, path, caption string) Delivery {
+	defer holdFile(path)()
+	files, err := fitForUpload(path, c.Notifier.MaxUploadBytes(), c.Logf)
+	if err != nil {
+		c.Logf("Preparing %s failed: %v", path, err)
+		c.Notifier.SendText(ctx, fmt.Sprintf("Cannot send %s: %v", path, err))
+		return Delivery{Notifier: c.Notifier.Name(), Err: err}
+	}
+	defer removeUploadCopies(path, files)
+
+	var d Delivery
+	for i, f := range files {
+		if d, err = c.Notifier.SendVideo(ctx, f, partCaption(caption, i, len(files))); err != nil {
+			c.Logf("Sending %s failed: %v", f, err)
+			break
+		}
+	}
+	d.Notifier, d.Err = c.Notifier.Name(), err
+	return d
+}
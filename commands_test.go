@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	ownChat     = 42
+	foreignChat = 666
+)
+
+// sentMessage is a call the bot made to the Bot API.
+type sentMessage struct {
+	method string
+	chatID string
+	text   string
+}
+
+// fakeBotAPI serves the Bot API methods BotCommands uses: updates are
+// handed out once and everything sent is recorded.
+type fakeBotAPI struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	nextID   int
+	messages int
+	updates  []map[string]any
+
+	sent chan sentMessage
+}
+
+func newFakeBotAPI(t *testing.T) *fakeBotAPI {
+	f := &fakeBotAPI{sent: make(chan sentMessage, 16)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// endpoint is the API endpoint to pass to NewTelegramNotifier.
+func (f *fakeBotAPI) endpoint() string { return f.URL + "/bot%s/%s" }
+
+// command queues a command message from chatID.
+func (f *fakeBotAPI) command(chatID int64, text string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	cmd, _, _ := strings.Cut(text, " ")
+	f.updates = append(f.updates, map[string]any{
+		"update_id": f.nextID,
+		"message": map[string]any{
+			"message_id": f.nextID,
+			"date":       time.Now().Unix(),
+			"chat":       map[string]any{"id": chatID, "type": "private"},
+			"from":       map[string]any{"id": chatID, "is_bot": false, "first_name": "T", "username": fmt.Sprint("user", chatID)},
+			"text":       text,
+			"entities":   []map[string]any{{"type": "bot_command", "offset": 0, "length": len(cmd)}},
+		},
+	})
+}
+
+func (f *fakeBotAPI) serve(w http.ResponseWriter, r *http.Request) {
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	r.ParseMultipartForm(1 << 20)
+
+	var result any
+	switch method {
+	case "getMe":
+		result = map[string]any{"id": 1, "is_bot": true, "first_name": "Bot", "username": "test_bot"}
+	case "getUpdates":
+		f.mu.Lock()
+		updates := f.updates
+		f.updates = nil
+		f.mu.Unlock()
+		if len(updates) == 0 {
+			// A short long-poll.
+			time.Sleep(20 * time.Millisecond)
+			updates = []map[string]any{}
+		}
+		result = updates
+	case "sendMessage", "sendPhoto", "sendVideo":
+		f.mu.Lock()
+		f.messages++
+		id := f.messages
+		f.mu.Unlock()
+		text := r.FormValue("text")
+		if text == "" {
+			text = r.FormValue("caption")
+		}
+		f.sent <- sentMessage{method: method, chatID: r.FormValue("chat_id"), text: text}
+		result = map[string]any{"message_id": id, "date": time.Now().Unix(), "chat": map[string]any{"id": ownChat, "type": "private"}}
+	default:
+		json.NewEncoder(w).Encode(map[string]any{"ok": false, "error_code": 404, "description": "Not Found: method " + method})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+}
+
+// next returns the next message the bot sent.
+func (f *fakeBotAPI) next(t *testing.T) sentMessage {
+	t.Helper()
+	select {
+	case m := <-f.sent:
+		return m
+	case <-time.After(10 * time.Second):
+		t.Fatal("the bot sent nothing")
+		return sentMessage{}
+	}
+}
+
+// logBuffer collects log lines from several goroutines.
+type logBuffer struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *logBuffer) Logf(format string, args ...any) {
+	l.mu.Lock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+	l.mu.Unlock()
+}
+
+func (l *logBuffer) contains(substr string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range l.lines {
+		if strings.Contains(line, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestBotCommands(t *testing.T) {
+	api := newFakeBotAPI(t)
+	n, err := NewTelegramNotifier("tg", "123:abc", ownChat, api.endpoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := testSession(t)
+	s.Monitor.Observe(false)
+	logs := &logBuffer{}
+	c := &BotCommands{Notifier: n, Session: s, Logf: logs.Logf}
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.Run(stop)
+
+	tests := []struct {
+		command string
+		reply   string
+		check   func() error
+	}{
+		{"/disarm", "Disarmed", func() error {
+			if !s.Monitor.Paused() {
+				return fmt.Errorf("monitor not paused")
+			}
+			return nil
+		}},
+		{"/status", "State: disarmed (paused)", nil},
+		{"/arm", "Armed", func() error {
+			if s.Monitor.Paused() {
+				return fmt.Errorf("monitor still paused")
+			}
+			return nil
+		}},
+		{"/status", "State: armed", nil},
+		{"/help", "Commands: /arm", nil},
+		{"/clip x", "Usage: /clip", nil},
+		{"/last", "No recordings yet.", nil},
+		{"/frobnicate", "Unknown command /frobnicate", nil},
+	}
+	for _, tt := range tests {
+		api.command(ownChat, tt.command)
+		m := api.next(t)
+		if m.method != "sendMessage" || m.chatID != fmt.Sprint(ownChat) || !strings.Contains(m.text, tt.reply) {
+			t.Errorf("%s: bot sent %+v, want a message to %d containing %q", tt.command, m, ownChat, tt.reply)
+		}
+		if tt.check != nil {
+			if err := tt.check(); err != nil {
+				t.Errorf("%s: %v", tt.command, err)
+			}
+		}
+	}
+
+	// A foreign chat gets no answer and changes nothing. Its command is
+	// handled before the /help that follows it in the same batch.
+	api.command(foreignChat, "/disarm")
+	api.command(ownChat, "/help")
+	if m := api.next(t); m.chatID != fmt.Sprint(ownChat) || !strings.Contains(m.text, "Commands:") {
+		t.Errorf("bot sent %+v, want only the /help reply", m)
+	}
+	if s.Monitor.Paused() {
+		t.Error("/disarm from a foreign chat paused the monitor")
+	}
+	if !logs.contains(fmt.Sprintf("Rejected /disarm from chat %d (@user%d)", foreignChat, foreignChat)) {
+		t.Error("rejection not logged")
+	}
+	select {
+	case m := <-api.sent:
+		t.Errorf("unexpected message %+v", m)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestBotClip(t *testing.T) {
+	api := newFakeBotAPI(t)
+	n, err := NewTelegramNotifier("tg", "123:abc", ownChat, api.endpoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := testSession(t)
+	c := &BotCommands{Notifier: n, Session: s, Logf: t.Logf}
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.Run(stop)
+
+	api.command(ownChat, "/clip 1")
+	if m := api.next(t); !strings.Contains(m.text, "Recording 1s") {
+		t.Errorf("bot sent %+v, want the recording notice", m)
+	}
+	if m := api.next(t); m.method != "sendVideo" || !strings.HasPrefix(m.text, "Clip - ") {
+		t.Errorf("bot sent %+v, want the clip", m)
+	}
+}
+
+func TestBotClipDuringShutdown(t *testing.T) {
+	api := newFakeBotAPI(t)
+	n, err := NewTelegramNotifier("tg", "123:abc", ownChat, api.endpoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := testSession(t)
+	if !s.Shutdown(time.Second) {
+		t.Fatal("Shutdown() timed out with nothing running")
+	}
+	c := &BotCommands{Notifier: n, Session: s, Logf: t.Logf}
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.Run(stop)
+
+	for _, tt := range []struct{ command, reply string }{
+		{"/clip 1", "Recording failed: " + ErrShuttingDown.Error()},
+		{"/snap", "Snapshot failed: " + ErrShuttingDown.Error()},
+	} {
+		api.command(ownChat, tt.command)
+		if m := api.next(t); m.method != "sendMessage" || m.text != tt.reply {
+			t.Errorf("%s: bot sent %+v, want %q", tt.command, m, tt.reply)
+		}
+	}
+	select {
+	case m := <-api.sent:
+		t.Errorf("unexpected message %+v", m)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
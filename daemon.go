@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const defaultDrainTimeout = 30 * time.Second
+
+// monitorOptions control how monitor runs and shuts down.
+type monitorOptions struct {
+	// Drain bounds how long shutdown waits for a recording in progress and
+	// for queued uploads.
+	Drain time.Duration
+	// Overrides is applied again after SIGHUP reloads the config file, so
+	// command line flags keep winning.
+	Overrides func(cfg *Config)
+}
+
+func pidPath() string {
+	return filepath.Join(configDir(), "iseeyougo.pid")
+}
+
+// writePIDFile claims path for this process. It fails if the file names
+// another process that is still running; a stale file is replaced.
+func writePIDFile(path string) (release func(), err error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			if err := f.Close(); err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		data, _ := os.ReadFile(path)
+		pid, convErr := strconv.Atoi(strings.TrimSpace(string(data)))
+		if convErr == nil && pid > 0 && pid != os.Getpid() && processAlive(pid) {
+			return nil, fmt.Errorf("already running as pid %d (%s)", pid, path)
+		}
+		log.Printf("Removing stale PID file %s", path)
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("cannot create %s", path)
+}
+
+// handleSignals closes stop on the first SIGINT or SIGTERM and reloads the
+// config on SIGHUP. A second SIGINT or SIGTERM exits at once.
+func handleSignals(s *Session, opts monitorOptions, stop chan struct{}) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+	go func() {
+		stopping := false
+		for sig := range sigs {
+			switch {
+			case sig == syscall.SIGHUP:
+				reloadConfig(s, opts)
+			case stopping:
+				log.Printf("Received %v again, exiting without cleanup", sig)
+				os.Exit(1)
+			default:
+				log.Printf("Received %v, shutting down (again to force)", sig)
+				stopping = true
+				close(stop)
+			}
+		}
+	}()
+}
+
+// reloadConfig re-reads the config file. Notifiers, retention, motion and
+// recording settings take effect for the next recording; the camera, lid
+// sensor, pre-roll and bot commands keep their startup settings.
+func reloadConfig(s *Session, opts monitorOptions) {
+	cfg, err := readConfigFile(configPath())
+	if err == nil {
+		err = cfg.validate()
+	}
+	if err != nil {
+		log.Printf("Reload failed, keeping the current config: %v", err)
+		return
+	}
+	if opts.Overrides != nil {
+		opts.Overrides(&cfg)
+	}
+	setConfig(cfg)
+	initNotifiers()
+	s.SetMotion(motionGateFromConfig(cfg))
+	log.Printf("Configuration reloaded from %s", configPath())
+}
+
+// shutdown lets the session's recordings and deliveries finish, then tries
+// the upload queue once more, all within timeout.
+func shutdown(s *Session, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	s.PreRoll.Stop()
+	if !s.Shutdown(timeout) {
+		log.Printf("Recording or upload still running after %v, giving up on it", timeout)
+		return
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if outbox.Len() > 0 {
+		log.Printf("Sending %d queued upload(s)...", outbox.Len())
+	}
+	if n := outbox.Drain(ctx, findNotifier); n > 0 {
+		log.Printf("%d upload(s) left in the queue for next time", n)
+	}
+	log.Println("Stopped")
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"testing"
+)
+
+// useConfig sets the configuration for one test.
+func useConfig(t *testing.T, cfg Config) {
+	t.Helper()
+	old := currentConfig()
+	setConfig(cfg)
+	t.Cleanup(func() { setConfig(old) })
+}
+
+func TestReloadConfigKeepsConfigWithoutFile(t *testing.T) {
+	s := testSession(t)
+	useConfig(t, Config{OutputDir: "/srv/videos"})
+
+	reloadConfig(s, monitorOptions{})
+	if got := currentConfig().OutputDir; got != "/srv/videos" {
+		t.Errorf("OutputDir = %q after reloading a missing file, want it kept", got)
+	}
+}
+
+func TestReloadConfigAppliesOverrides(t *testing.T) {
+	s := testSession(t)
+	useConfig(t, Config{})
+	if err := os.WriteFile(configPath(), []byte(`{"output_dir": "/srv/videos", "motion_max_seconds": 60}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	reloadConfig(s, monitorOptions{Overrides: func(cfg *Config) { cfg.MotionMaxSeconds = 0 }})
+	cfg := currentConfig()
+	if cfg.OutputDir != "/srv/videos" || cfg.MotionMaxSeconds != 0 {
+		t.Errorf("reloaded %+v, want output_dir from the file and the motion override", cfg)
+	}
+}
+
+// TestReloadConfigConcurrent is for the race detector: reloads run while
+// recordings and the janitor read the config.
+func TestReloadConfigConcurrent(t *testing.T) {
+	s := testSession(t)
+	useConfig(t, Config{})
+	if err := os.WriteFile(configPath(), []byte(`{"retention_days": 7}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				videosDir()
+				retentionFromConfig(currentConfig())
+			}
+		}()
+	}
+	for j := 0; j < 20; j++ {
+		reloadConfig(s, monitorOptions{})
+	}
+	wg.Wait()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// maxRecentEvents is how many events a feed keeps for late subscribers.
+const maxRecentEvents = 100
+
+// Event is something that happened in a session, as reported by the API.
+type Event struct {
+	Time time.Time `json:"time"`
+	// Kind is "state", "trigger", "cooldown_rejected", "recording_started",
+	// "recording_saved" or "recording_failed".
+	Kind      string `json:"kind"`
+	State     string `json:"state,omitempty"`
+	Paused    bool   `json:"paused,omitempty"`
+	Trigger   string `json:"trigger,omitempty"`
+	Recording string `json:"recording,omitempty"` // recording ID
+	Error     string `json:"error,omitempty"`
+}
+
+func monitorEvent(ev MonitorEvent) Event {
+	kind := "state"
+	switch ev.Kind {
+	case EventTrigger:
+		kind = "trigger"
+	case EventCooldownRejected:
+		kind = "cooldown_rejected"
+	}
+	return Event{Time: ev.Time, Kind: kind, State: ev.State.String(), Paused: ev.Paused}
+}
+
+// eventFeed keeps the recent events and passes new ones to subscribers.
+// A subscriber that falls behind misses events rather than blocking the
+// monitor.
+type eventFeed struct {
+	mu     sync.Mutex
+	recent []Event
+	subs   map[chan Event]bool
+}
+
+func newEventFeed() *eventFeed {
+	return &eventFeed{subs: map[chan Event]bool{}}
+}
+
+func (f *eventFeed) publish(ev Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.recent = append(f.recent, ev)
+	if len(f.recent) > maxRecentEvents {
+		f.recent = f.recent[len(f.recent)-maxRecentEvents:]
+	}
+	for ch := range f.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// History returns the recent events, oldest first.
+func (f *eventFeed) History() []Event {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Event(nil), f.recent...)
+}
+
+// Subscribe returns a channel of new events; cancel stops them.
+func (f *eventFeed) Subscribe() (events <-chan Event, cancel func()) {
+	ch := make(chan Event, 16)
+	f.mu.Lock()
+	f.subs[ch] = true
+	f.mu.Unlock()
+	return ch, func() {
+		f.mu.Lock()
+		delete(f.subs, ch)
+		f.mu.Unlock()
+	}
+}
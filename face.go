@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	defaultFaceEvery = 5
+	maxFaceCrops     = 20
+)
+
+// FaceResult summarises face detection over one recording.
+type FaceResult struct {
+	// Frames are the (sampled) frame numbers in which faces were found.
+	Frames []int
+	// MaxFaces is the largest number of faces seen in a single frame.
+	MaxFaces int
+	// Crops are the saved face images; Best is the largest face.
+	Crops []string
+	Best  string
+}
+
+// faceModel hides the difference between Haar cascades and the DNN detector.
+type faceModel interface {
+	detect(img gocv.Mat) []image.Rectangle
+	Close() error
+}
+
+type cascadeModel struct {
+	c gocv.CascadeClassifier
+}
+
+func (m *cascadeModel) detect(img gocv.Mat) []image.Rectangle { return m.c.DetectMultiScale(img) }
+func (m *cascadeModel) Close() error                          { return m.c.Close() }
+
+// ynModel wraps OpenCV's FaceDetectorYN (YuNet ONNX models).
+type ynModel struct {
+	fd    gocv.FaceDetectorYN
+	faces gocv.Mat
+}
+
+func (m *ynModel) detect(img gocv.Mat) []image.Rectangle {
+	m.fd.SetInputSize(image.Pt(img.Cols(), img.Rows()))
+	m.fd.Detect(img, &m.faces)
+	var rects []image.Rectangle
+	for i := 0; i < m.faces.Rows(); i++ {
+		x, y := int(m.faces.GetFloatAt(i, 0)), int(m.faces.GetFloatAt(i, 1))
+		w, h := int(m.faces.GetFloatAt(i, 2)), int(m.faces.GetFloatAt(i, 3))
+		rects = append(rects, image.Rect(x, y, x+w, y+h))
+	}
+	return rects
+}
+
+func (m *ynModel) Close() error {
+	m.faces.Close()
+	m.fd.Close()
+	return nil
+}
+
+// loadFaceModel loads a Haar cascade (.xml) or a YuNet model (.onnx).
+func loadFaceModel(path string) (faceModel, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("face model: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".xml":
+		c := gocv.NewCascadeClassifier()
+		if !c.Load(path) {
+			c.Close()
+			return nil, fmt.Errorf("cannot load cascade %s", path)
+		}
+		return &cascadeModel{c: c}, nil
+	case ".onnx":
+		return &ynModel{fd: gocv.NewFaceDetectorYN(path, "", image.Pt(320, 320)), faces: gocv.NewMat()}, nil
+	}
+	return nil, fmt.Errorf("unsupported face model %s (want .xml or .onnx)", path)
+}
+
+// faceAnalyzer runs detection on every n-th frame in the background so a
+// slow model drops samples instead of recording frames.
+type faceAnalyzer struct {
+	model  faceModel
+	every  int
+	dir    string // crops go here
+	frames chan faceSample
+	done   chan struct{}
+
+	mu       sync.Mutex
+	result   FaceResult
+	best     gocv.Mat
+	bestArea int
+	err      error
+}
+
+type faceSample struct {
+	n   int
+	img gocv.Mat
+}
+
+func newFaceAnalyzer(modelPath string, every int, cropDir string) (*faceAnalyzer, error) {
+	model, err := loadFaceModel(modelPath)
+	if err != nil {
+		return nil, err
+	}
+	if every <= 0 {
+		every = defaultFaceEvery
+	}
+	a := &faceAnalyzer{
+		model:  model,
+		every:  every,
+		dir:    cropDir,
+		frames: make(chan faceSample, 2),
+		done:   make(chan struct{}),
+		best:   gocv.NewMat(),
+	}
+	go a.run()
+	return a, nil
+}
+
+// Offer queues frame n for analysis if it is sampled. The frame is skipped
+// when the analyzer is still busy, so recording never waits for it.
+func (a *faceAnalyzer) Offer(n int, img gocv.Mat) {
+	if n%a.every != 0 {
+		return
+	}
+	s := faceSample{n: n, img: img.Clone()}
+	select {
+	case a.frames <- s:
+	default:
+		s.img.Close()
+	}
+}
+
+func (a *faceAnalyzer) run() {
+	defer close(a.done)
+	for s := range a.frames {
+		a.analyze(s)
+		s.img.Close()
+	}
+}
+
+func (a *faceAnalyzer) analyze(s faceSample) {
+	rects := a.model.detect(s.img)
+	if len(rects) == 0 {
+		return
+	}
+	bounds := image.Rect(0, 0, s.img.Cols(), s.img.Rows())
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.result.Frames = append(a.result.Frames, s.n)
+	if len(rects) > a.result.MaxFaces {
+		a.result.MaxFaces = len(rects)
+	}
+
+	for i, r := range rects {
+		r = r.Intersect(bounds)
+		if r.Empty() {
+			continue
+		}
+		crop := s.img.Region(r)
+		if area := r.Dx() * r.Dy(); area > a.bestArea {
+			a.bestArea = area
+			crop.CopyTo(&a.best)
+		}
+		if len(a.result.Crops) < maxFaceCrops {
+			if err := os.MkdirAll(a.dir, 0o755); err != nil {
+				a.err = err
+			} else {
+				path := filepath.Join(a.dir, fmt.Sprintf("frame%05d_%d.jpg", s.n, i))
+				if gocv.IMWrite(path, crop) {
+					a.result.Crops = append(a.result.Crops, path)
+				}
+			}
+		}
+		crop.Close()
+	}
+}
+
+// Finish waits for pending samples, saves the best crop to bestPath and
+// returns the result.
+func (a *faceAnalyzer) Finish(bestPath string) (FaceResult, error) {
+	close(a.frames)
+	<-a.done
+	defer a.model.Close()
+	defer a.best.Close()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.best.Empty() {
+		if gocv.IMWrite(bestPath, a.best) {
+			a.result.Best = bestPath
+		} else if a.err == nil {
+			a.err = fmt.Errorf("cannot write %s", bestPath)
+		}
+	}
+	return a.result, a.err
+}
+
+// facesDetected is the caption text, e.g. "2 faces detected".
+func facesDetected(n int) string {
+	if n == 1 {
+		return "1 face detected"
+	}
+	return fmt.Sprintf("%d faces detected", n)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+// testCascade is OpenCV's stock frontal face cascade.
+const testCascade = "testdata/haarcascade_frontalface_default.xml"
+
+// detectFaces runs a faceAnalyzer over img as frame 0 and returns the
+// result and the crop directory.
+func detectFaces(t *testing.T, img gocv.Mat) (FaceResult, string) {
+	t.Helper()
+	dir := t.TempDir()
+	a, err := newFaceAnalyzer(testCascade, 1, filepath.Join(dir, "faces"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Offer(0, img)
+	res, err := a.Finish(filepath.Join(dir, "best.jpg"))
+	if err != nil {
+		t.Fatalf("Finish(): %v", err)
+	}
+	return res, dir
+}
+
+func TestDetectFaces(t *testing.T) {
+	img := gocv.IMRead("testdata/faces/face.jpg", gocv.IMReadColor)
+	if img.Empty() {
+		t.Fatal("cannot read testdata/faces/face.jpg")
+	}
+	defer img.Close()
+
+	res, dir := detectFaces(t, img)
+	if res.MaxFaces != 1 {
+		t.Errorf("MaxFaces = %d, want 1", res.MaxFaces)
+	}
+	if !reflect.DeepEqual(res.Frames, []int{0}) {
+		t.Errorf("Frames = %v, want [0]", res.Frames)
+	}
+	wantCrop := filepath.Join(dir, "faces", "frame00000_0.jpg")
+	if !reflect.DeepEqual(res.Crops, []string{wantCrop}) {
+		t.Errorf("Crops = %v, want [%s]", res.Crops, wantCrop)
+	}
+	if res.Best != filepath.Join(dir, "best.jpg") {
+		t.Errorf("Best = %q, want best.jpg", res.Best)
+	}
+	for _, f := range append(res.Crops, res.Best) {
+		crop := gocv.IMRead(f, gocv.IMReadColor)
+		if crop.Empty() {
+			t.Errorf("%s is not a readable image", f)
+		} else if crop.Cols() >= img.Cols() || crop.Rows() >= img.Rows() {
+			t.Errorf("%s is %dx%d, not a crop of the %dx%d frame", f, crop.Cols(), crop.Rows(), img.Cols(), img.Rows())
+		}
+		crop.Close()
+	}
+}
+
+func TestDetectFacesNone(t *testing.T) {
+	img := gocv.NewMatWithSize(240, 320, gocv.MatTypeCV8UC3)
+	defer img.Close()
+
+	res, dir := detectFaces(t, img)
+	if res.MaxFaces != 0 || len(res.Crops) != 0 || res.Best != "" {
+		t.Errorf("blank frame: %+v, want no faces", res)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "best.jpg")); !os.IsNotExist(err) {
+		t.Errorf("best.jpg written for a blank frame: %v", err)
+	}
+}
+
+func TestOfferSkipsUnsampledFrames(t *testing.T) {
+	img := gocv.IMRead("testdata/faces/face.jpg", gocv.IMReadColor)
+	defer img.Close()
+	a, err := newFaceAnalyzer(testCascade, 5, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 1; n < 5; n++ {
+		a.Offer(n, img)
+	}
+	res, err := a.Finish(filepath.Join(t.TempDir(), "best.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.MaxFaces != 0 {
+		t.Errorf("unsampled frames were analyzed: %+v", res)
+	}
+}
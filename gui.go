@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -16,28 +17,31 @@ import (
 	"fyne.io/fyne/v2/driver/desktop"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
-	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"gocv.io/x/gocv"
 )
 
 type GUI struct {
-	app           fyne.App
-	window        fyne.Window
-	deviceSelect  *widget.Select
-	durationEntry *widget.Entry
-	botTokenEntry *widget.Entry
-	chatIDEntry   *widget.Entry
-	statusLabel   *widget.Label
-	startButton   *widget.Button
-	stopButton    *widget.Button
-	logText       *widget.Entry
-	logContainer  *container.Scroll
-	showLogButton *widget.Button
-	logVisible    bool
-	mainContainer *fyne.Container
-
+	app            fyne.App
+	window         fyne.Window
+	deviceSelect   *widget.Select
+	durationEntry  *widget.Entry
+	motionEntry    *widget.Entry
+	idleEntry      *widget.Entry
+	motionPctEntry *widget.Entry
+	botTokenEntry  *widget.Entry
+	chatIDEntry    *widget.Entry
+	statusLabel    *widget.Label
+	startButton    *widget.Button
+	stopButton     *widget.Button
+	logText        *widget.Entry
+	logContainer   *container.Scroll
+	showLogButton  *widget.Button
+	logVisible     bool
+	mainContainer  *fyne.Container
+
+	status         string
 	isMonitoring   bool
 	stopChannel    chan bool
+	session        *Session
 	selectedDevice Device
 	recordDuration time.Duration
 	isHidden       bool
@@ -60,15 +64,20 @@ func NewGUI() *GUI {
 
 	gui.setupUI()
 	gui.setupSystemTray()
-	gui.loadDevices()
 	gui.loadConfiguration()
+	gui.loadDevices()
+	if len(notifierConfigs(currentConfig())) > 0 {
+		gui.setupNotifiers()
+	}
+	openOutbox(gui.logf, func(int) { gui.setStatus(gui.status) })
 
 	return gui
 }
 
 func (g *GUI) setupUI() {
 	// Status section
-	g.statusLabel = widget.NewLabel("Ready")
+	g.status = "Ready"
+	g.statusLabel = widget.NewLabel(g.status)
 	g.statusLabel.Importance = widget.MediumImportance
 
 	// Camera selection
@@ -80,7 +89,18 @@ func (g *GUI) setupUI() {
 	// Recording duration
 	g.durationEntry = widget.NewEntry()
 	g.durationEntry.SetText("15")
-	g.durationEntry.SetPlaceHolder("Seconds")
+	g.durationEntry.SetPlaceHolder("Seconds (min)")
+
+	// Motion-gated recording: how long it may run on, when it stops, and
+	// how much of the picture has to change.
+	g.motionEntry = widget.NewEntry()
+	g.motionEntry.SetPlaceHolder("Max with motion")
+
+	g.idleEntry = widget.NewEntry()
+	g.idleEntry.SetPlaceHolder(fmt.Sprintf("Idle stop (%ds)", defaultMotionIdle))
+
+	g.motionPctEntry = widget.NewEntry()
+	g.motionPctEntry.SetPlaceHolder(fmt.Sprintf("Motion %% (%g)", defaultMotionThreshold*100))
 
 	// Telegram configuration
 	g.botTokenEntry = widget.NewEntry()
@@ -114,6 +134,10 @@ func (g *GUI) setupUI() {
 		g.deviceSelect, g.durationEntry,
 	)
 
+	motionRow := container.NewGridWithColumns(3,
+		g.motionEntry, g.idleEntry, g.motionPctEntry,
+	)
+
 	telegramRow := container.NewGridWithColumns(3,
 		g.botTokenEntry, g.chatIDEntry, testButton,
 	)
@@ -126,7 +150,7 @@ func (g *GUI) setupUI() {
 	g.logContainer.SetMinSize(fyne.NewSize(380, 80))
 
 	// Create compact labels
-	cameraLabel := widget.NewLabel("Camera & Duration:")
+	cameraLabel := widget.NewLabel("Camera, Duration & Motion:")
 	cameraLabel.TextStyle.Bold = true
 	telegramLabel := widget.NewLabel("Telegram (optional):")
 	telegramLabel.TextStyle.Bold = true
@@ -139,6 +163,7 @@ func (g *GUI) setupUI() {
 		widget.NewSeparator(),
 		cameraLabel,
 		cameraRow,
+		motionRow,
 		telegramLabel,
 		telegramRow,
 		widget.NewSeparator(),
@@ -164,6 +189,7 @@ func (g *GUI) loadDevices() {
 	// Scan for devices in background, but update UI on main thread
 	devices = []Device{} // Reset global devices
 	enumerate(10)        // Scan more devices for GUI
+	enumerateSources(currentConfig().Sources)
 
 	if len(devices) == 0 {
 		g.appendLog("No cameras found!")
@@ -172,7 +198,7 @@ func (g *GUI) loadDevices() {
 
 	options := make([]string, len(devices))
 	for i, d := range devices {
-		options[i] = fmt.Sprintf("Camera %d - %s @ %dfps", d.Id, d.Resolution, d.FPS)
+		options[i] = d.Label()
 	}
 
 	g.deviceSelect.Options = options
@@ -197,8 +223,14 @@ func (g *GUI) loadConfiguration() {
 		g.appendLog("Error reading configuration: " + err.Error())
 		return
 	}
+	setConfig(cfg)
+	if err := cfg.validate(); err != nil {
+		// Recordings fall back to plaintext; say so before the first one.
+		g.appendLog(fmt.Sprintf("WARNING: %v - recordings will NOT be encrypted", err))
+		dialog.ShowError(fmt.Errorf("%v\n\nRecordings will not be encrypted until this is fixed in %s.", err, path), g.window)
+	}
 
-	if cfg.BotToken != "PUT_YOUR_BOT_TOKEN_HERE" && cfg.BotToken != "" {
+	if cfg.BotToken != placeholderBotToken && cfg.BotToken != "" {
 		g.botTokenEntry.SetText(cfg.BotToken)
 	}
 
@@ -206,6 +238,16 @@ func (g *GUI) loadConfiguration() {
 		g.chatIDEntry.SetText(strconv.FormatInt(cfg.ChatID, 10))
 	}
 
+	if cfg.MotionMaxSeconds > 0 {
+		g.motionEntry.SetText(strconv.Itoa(cfg.MotionMaxSeconds))
+	}
+	if cfg.MotionIdleSeconds > 0 {
+		g.idleEntry.SetText(strconv.Itoa(cfg.MotionIdleSeconds))
+	}
+	if cfg.MotionThreshold > 0 {
+		g.motionPctEntry.SetText(strconv.FormatFloat(cfg.MotionThreshold*100, 'g', -1, 64))
+	}
+
 	g.appendLog("Configuration loaded")
 }
 
@@ -216,8 +258,7 @@ func (g *GUI) onDeviceSelected(selected string) {
 
 	// Parse device index from selection
 	for _, d := range devices {
-		option := fmt.Sprintf("Camera %d - %s @ %dfps", d.Id, d.Resolution, d.FPS)
-		if option == selected {
+		if d.Label() == selected {
 			g.selectedDevice = d
 			g.appendLog(fmt.Sprintf("Selected: %s", selected))
 			return
@@ -239,13 +280,49 @@ func (g *GUI) startMonitoring() {
 	}
 	g.recordDuration = time.Duration(duration) * time.Second
 
+	motionMax := 0
+	if g.motionEntry.Text != "" {
+		if motionMax, err = strconv.Atoi(g.motionEntry.Text); err != nil || motionMax < 0 {
+			dialog.ShowError(fmt.Errorf("please enter a valid motion limit in seconds, or leave it empty"), g.window)
+			return
+		}
+	}
+	idle := 0
+	if g.idleEntry.Text != "" {
+		if idle, err = strconv.Atoi(g.idleEntry.Text); err != nil || idle <= 0 {
+			dialog.ShowError(fmt.Errorf("please enter how many seconds without motion end a recording, or leave it empty"), g.window)
+			return
+		}
+	}
+	threshold := 0.0
+	if g.motionPctEntry.Text != "" {
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(g.motionPctEntry.Text, "%"), 64)
+		if err != nil || pct <= 0 || pct > 100 {
+			dialog.ShowError(fmt.Errorf("please enter the percentage of the picture that must change, or leave it empty"), g.window)
+			return
+		}
+		threshold = pct / 100
+	}
+	updateConfig(func(cfg *Config) {
+		cfg.MotionMaxSeconds = motionMax
+		cfg.MotionIdleSeconds = idle
+		cfg.MotionThreshold = threshold
+	})
+
 	// Save configuration
 	g.saveConfiguration()
 
-	// Setup Telegram if configured
-	if g.botTokenEntry.Text != "" && g.chatIDEntry.Text != "" {
-		g.setupTelegram()
+	g.setupNotifiers()
+
+	cfg := currentConfig()
+	sensor, err := newLidSensor(cfg)
+	if err != nil {
+		dialog.ShowError(err, g.window)
+		return
 	}
+	g.session = NewSession(g.selectedDevice, g.recordDuration, sensor)
+	g.session.Motion = motionGateFromConfig(cfg)
+	g.session.Logf = g.logf
 
 	// Start monitoring
 	g.isMonitoring = true
@@ -253,10 +330,10 @@ func (g *GUI) startMonitoring() {
 	g.botTokenEntry.Disable()
 	g.chatIDEntry.Disable()
 	g.stopButton.Enable()
-	g.statusLabel.SetText("Monitoring - waiting for lid close/open")
+	g.setStatus("Monitoring - waiting for lid close/open")
 	g.appendLog("Started monitoring lid state...")
 
-	go g.monitorLidState()
+	go g.monitorLidState(g.session, sensor)
 }
 
 func (g *GUI) stopMonitoring() {
@@ -267,213 +344,130 @@ func (g *GUI) stopMonitoring() {
 
 	g.startButton.Enable()
 	g.stopButton.Disable()
-	g.statusLabel.SetText("Stopped")
+	g.setStatus("Stopped")
 	g.appendLog("Monitoring stopped")
 }
 
-func (g *GUI) monitorLidState() {
-	ticker := time.NewTicker(500 * time.Millisecond)
-	defer ticker.Stop()
+func (g *GUI) monitorLidState(s *Session, sensor LidSensor) {
+	defer closeLidSensor(sensor)
+	done := make(chan struct{})
+	defer close(done)
+
+	go s.Monitor.Run(done)
+	startBotCommands(s, done, g.logf)
+	startAPI(s, done, g.logf)
+	startJanitor(done, g.logf)
 
-	armed := false
-	var prev bool
-	havePrev := false
-	lastTrigger := time.Time{}
-	const cooldown = 5 * time.Second
+	pre := s.PreRoll
+	defer pre.Stop()
 
+	first := true
+	paused := false
 	for {
 		select {
 		case <-g.stopChannel:
 			return
-		case <-ticker.C:
-			open, err := checkLidStatus()
-			if err != nil {
-				continue
+		case ev, ok := <-s.Monitor.Events():
+			if !ok {
+				return
 			}
 
-			if !havePrev {
-				prev = open
-				havePrev = true
-				if !open {
-					g.statusLabel.SetText("Monitoring - lid closed (armed)")
-				} else {
-					g.statusLabel.SetText("Monitoring - lid open")
+			switch ev.Kind {
+			case EventState:
+				if paused && !ev.Paused {
+					g.appendLog("Monitoring re-armed")
+					g.setStatus("Monitoring - waiting for lid close/open")
 				}
-			}
-
-			if !open && prev {
-				armed = true
-				g.statusLabel.SetText("Monitoring - lid closed (armed)")
-				g.appendLog("Lid closed - recording armed")
-			}
-
-			if armed && !prev && open {
-				if time.Since(lastTrigger) > cooldown {
-					lastTrigger = time.Now()
-					armed = false
-					g.statusLabel.SetText("Recording...")
-					g.appendLog("Lid opened - starting recording!")
-					go g.recordVideo()
-				} else {
-					g.appendLog("Lid opened but still in cooldown period")
+				paused = ev.Paused
+
+				switch {
+				case ev.Paused:
+					g.setStatus("Monitoring - disarmed remotely")
+					g.appendLog("Monitoring disarmed")
+					pre.Stop()
+				case ev.State == Armed:
+					g.setStatus("Monitoring - lid closed (armed)")
+					if !first {
+						g.appendLog("Lid closed - recording armed")
+					}
+					if err := pre.Start(); err != nil {
+						g.appendLog(fmt.Sprintf("Pre-roll unavailable: %v", err))
+					}
+				case ev.State == Disarmed:
+					if first {
+						g.setStatus("Monitoring - lid open")
+					}
+					pre.Stop()
 				}
+				first = false
+			case EventTrigger:
+				g.setStatus("Recording...")
+				g.appendLog("Lid opened - starting recording!")
+				go g.recordVideo(s)
+			case EventCooldownRejected:
+				g.appendLog("Lid opened but still in cooldown period")
 			}
-
-			prev = open
 		}
 	}
 }
 
-func (g *GUI) recordVideo() {
-	g.appendLog("Starting video recording...")
+func (g *GUI) recordVideo(s *Session) {
+	g.appendLog(fmt.Sprintf("Starting video recording (%v seconds)...", s.Duration.Seconds()))
 
-	cap, err := gocv.OpenVideoCapture(g.selectedDevice.Id)
-	if err != nil || !cap.IsOpened() {
-		g.appendLog(fmt.Sprintf("Error opening camera %d: %v", g.selectedDevice.Id, err))
-		g.statusLabel.SetText("Error - Camera unavailable")
+	rec := s.Record(0, TriggerLid)
+	defer rec.Release()
+	if rec.Err != nil {
+		g.appendLog(fmt.Sprintf("Recording failed: %v", rec.Err))
+		g.setStatus("Error - Recording failed")
 		return
 	}
-	defer cap.Close()
-
-	w, h := int(g.selectedDevice.Width), int(g.selectedDevice.Height)
-	if w == 0 || h == 0 {
-		w, h = 1280, 720
-	}
 
-	cap.Set(gocv.VideoCaptureFrameWidth, float64(w))
-	cap.Set(gocv.VideoCaptureFrameHeight, float64(h))
+	g.appendLog(fmt.Sprintf("Recording complete! Saved %d frames (%d dropped, %.1f fps) to: %s", rec.Frames, rec.Dropped, rec.FPS, rec.Path))
+	g.setStatus("Monitoring - recording complete")
 
-	fps := g.selectedDevice.FPS
-	if fps <= 0 {
-		fps = 30
-	}
-
-	home, err := os.UserHomeDir()
-	if err != nil {
-		g.appendLog(fmt.Sprintf("Error finding home dir: %v", err))
-		return
-	}
-
-	ts := time.Now().Format("20060102_150405")
-	dir := filepath.Join(home, "iseeyougo", "videos")
-	_ = os.MkdirAll(dir, 0o755)
-
-	filename := filepath.Join(dir, fmt.Sprintf("capture_%s.mp4", ts))
-
-	writer, err := gocv.VideoWriterFile(filename, "avc1", float64(fps), w, h, true)
-	if err != nil {
-		g.appendLog(fmt.Sprintf("Error creating video writer: %v", err))
-		g.statusLabel.SetText("Error - Cannot create video file")
-		return
-	}
-
-	img := gocv.NewMat()
-	defer img.Close()
-
-	deadline := time.Now().Add(g.recordDuration)
-	tick := time.NewTicker(time.Second / time.Duration(fps))
-	defer tick.Stop()
-
-	g.appendLog(fmt.Sprintf("Recording %dx%d @ %dfps for %v seconds...", w, h, fps, g.recordDuration.Seconds()))
-
-	frameCount := 0
-	for range tick.C {
-		if time.Now().After(deadline) {
-			break
-		}
-
-		if ok := cap.Read(&img); !ok || img.Empty() {
-			continue
-		}
-
-		if err := writer.Write(img); err != nil {
-			g.appendLog(fmt.Sprintf("Error writing frame: %v", err))
-			continue
-		}
-		frameCount++
-	}
-
-	writer.Close()
-	time.Sleep(1 * time.Second)
-
-	g.appendLog(fmt.Sprintf("Recording complete! Saved %d frames to: %s", frameCount, filename))
-	g.statusLabel.SetText("Monitoring - recording complete")
-
-	// Send to Telegram if configured
-	if bot != nil {
-		g.sendVideoToTelegram(filename)
+	if len(activeNotifiers()) > 0 {
+		g.appendLog("Sending video...")
+		s.Deliver(rec)
 	}
 }
 
-func (g *GUI) sendVideoToTelegram(videoPath string) {
-	g.appendLog("Sending video to Telegram...")
-
-	fileInfo, err := os.Stat(videoPath)
-	if err != nil {
-		g.appendLog(fmt.Sprintf("Cannot access video file: %v", err))
-		return
-	}
-
-	fileSizeMB := float64(fileInfo.Size()) / (1024 * 1024)
-	if fileSizeMB > 50 {
-		g.appendLog(fmt.Sprintf("Video too large for Telegram (%.1f MB > 50MB)", fileSizeMB))
-		return
-	}
-
-	video := tgbotapi.NewVideo(config.ChatID, tgbotapi.FilePath(videoPath))
-	video.Caption = fmt.Sprintf("Laptop lid opened - %s", time.Now().Format("Jan 2, 15:04:05"))
-
-	_, botErr := bot.Send(video)
-	if botErr != nil {
-		g.appendLog(fmt.Sprintf("Failed to send video to Telegram: %v", botErr))
+func (g *GUI) testTelegramConnection() {
+	chatID, err := strconv.ParseInt(strings.TrimSpace(g.chatIDEntry.Text), 10, 64)
+	if g.chatIDEntry.Text != "" && err != nil {
+		dialog.ShowError(fmt.Errorf("invalid chat ID: %v", err), g.window)
 		return
 	}
-
-	g.appendLog("Video sent to Telegram successfully!")
-}
-
-func (g *GUI) testTelegramConnection() {
-	if g.botTokenEntry.Text == "" {
-		dialog.ShowError(fmt.Errorf("please enter bot token"), g.window)
+	// The same notifier the entries become once saved.
+	n, err := NewTelegramNotifier("telegram", g.botTokenEntry.Text, chatID, "")
+	if err != nil {
+		dialog.ShowError(err, g.window)
 		return
 	}
 
 	g.appendLog("Testing Telegram connection...")
 
-	testBot, err := tgbotapi.NewBotAPI(g.botTokenEntry.Text)
-	if err != nil {
-		g.appendLog(fmt.Sprintf("Telegram connection failed: %v", err))
-		dialog.ShowError(fmt.Errorf("invalid bot token: %v", err), g.window)
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	if _, err := n.SendText(ctx, "IseeYouGo test message - connection successful!"); err != nil {
+		g.appendLog(fmt.Sprintf("Telegram connection test failed: %v", err))
+		dialog.ShowError(err, g.window)
 		return
 	}
 
-	g.appendLog(fmt.Sprintf("Successfully connected to Telegram bot: @%s", testBot.Self.UserName))
-
-	if g.chatIDEntry.Text != "" {
-		chatID, err := strconv.ParseInt(g.chatIDEntry.Text, 10, 64)
-		if err == nil {
-			msg := tgbotapi.NewMessage(chatID, "IseeYouGo test message - connection successful!")
-			if _, err := testBot.Send(msg); err != nil {
-				g.appendLog(fmt.Sprintf("Failed to send test message: %v", err))
-			} else {
-				g.appendLog("Test message sent successfully!")
-				dialog.ShowInformation("Success", "Telegram connection test successful!", g.window)
-			}
-		}
-	} else {
-		dialog.ShowInformation("Success", fmt.Sprintf("Bot connection successful (@%s)!\nAdd chat ID to send test messages.", testBot.Self.UserName), g.window)
-	}
+	g.appendLog(fmt.Sprintf("Test message sent by @%s", n.BotName()))
+	dialog.ShowInformation("Success", "Telegram connection test successful!", g.window)
 }
 
 func (g *GUI) saveConfiguration() {
 	path := configPath()
 
-	cfg := Config{}
+	cfg := currentConfig()
+	cfg.ChatID = 0
 
 	if g.botTokenEntry.Text != "" {
 		cfg.BotToken = g.botTokenEntry.Text
 	} else {
-		cfg.BotToken = "PUT_YOUR_BOT_TOKEN_HERE"
+		cfg.BotToken = placeholderBotToken
 	}
 
 	if g.chatIDEntry.Text != "" {
@@ -495,23 +489,25 @@ func (g *GUI) saveConfiguration() {
 		return
 	}
 
-	config = cfg
+	setConfig(cfg)
 	g.appendLog("Configuration saved")
 }
 
-func (g *GUI) setupTelegram() {
-	if config.BotToken == "PUT_YOUR_BOT_TOKEN_HERE" || config.ChatID == 0 {
-		return
-	}
-
-	var err error
-	bot, err = tgbotapi.NewBotAPI(config.BotToken)
-	if err != nil {
-		g.appendLog(fmt.Sprintf("Telegram bot error: %v", err))
-		return
+func (g *GUI) setupNotifiers() {
+	ns, errs := newNotifiers(currentConfig())
+	setNotifiers(ns)
+	for _, err := range errs {
+		g.appendLog(fmt.Sprintf("Notifier error: %v", err))
+	}
+	for _, n := range ns {
+		if t, ok := n.(*TelegramNotifier); !ok {
+			g.appendLog(fmt.Sprintf("Notifier %s ready", n.Name()))
+		} else if name := t.BotName(); name != "" {
+			g.appendLog(fmt.Sprintf("Telegram bot connected (@%s)", name))
+		} else {
+			g.appendLog(fmt.Sprintf("Telegram unreachable, %s will queue uploads until it is back", n.Name()))
+		}
 	}
-
-	g.appendLog(fmt.Sprintf("Telegram bot connected (@%s)", bot.Self.UserName))
 }
 
 func (g *GUI) appendLog(message string) {
@@ -526,6 +522,17 @@ func (g *GUI) appendLog(message string) {
 	g.logText.CursorRow = len(strings.Split(g.logText.Text, "\n"))
 }
 
+func (g *GUI) logf(format string, args ...any) {
+	g.appendLog(fmt.Sprintf(format, args...))
+}
+
+// setStatus shows text in the status line, followed by the upload queue
+// depth when something is waiting to be delivered.
+func (g *GUI) setStatus(text string) {
+	g.status = text
+	g.statusLabel.SetText(withQueue(text))
+}
+
 func (g *GUI) setupSystemTray() {
 	if desk, ok := g.app.(desktop.App); ok {
 		menu := fyne.NewMenu("IseeYouGo",
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LedgerEntry records the hash of one saved file. Hash covers the entry
+// itself, including Prev, the previous entry's Hash, so removing or editing
+// an entry breaks the chain from there on.
+type LedgerEntry struct {
+	Time   time.Time `json:"time"`
+	Path   string    `json:"path"`
+	Size   int64     `json:"size"`
+	SHA256 string    `json:"sha256"`
+	Prev   string    `json:"prev"`
+	Hash   string    `json:"hash"`
+	// Deleted marks a file removed by the retention policy.
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// ledgerMu serialises appends from concurrent recordings.
+var ledgerMu sync.Mutex
+
+func ledgerPath() string {
+	return filepath.Join(configDir(), "ledger.jsonl")
+}
+
+// Files lists everything the recording saved: the video, the snapshot and
+// any face crops.
+func (rec Recording) Files() []string {
+	var files []string
+	for _, f := range append([]string{rec.Path, rec.Snapshot, rec.Faces.Best}, rec.Faces.Crops...) {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// entryHash is the hash of e with its Hash field cleared.
+func entryHash(e LedgerEntry) string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func fileHash(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// readLedger returns all entries in order.
+func readLedger(path string) ([]LedgerEntry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []LedgerEntry
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var e LedgerEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return entries, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
+
+// appendLedger hashes files and appends one chained entry per file.
+func appendLedger(files ...string) error {
+	var entries []LedgerEntry
+	for _, file := range files {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		sum, size, err := fileHash(abs)
+		if err != nil {
+			return fmt.Errorf("hash %s: %w", file, err)
+		}
+		entries = append(entries, LedgerEntry{Path: abs, Size: size, SHA256: sum})
+	}
+	return writeLedger(entries)
+}
+
+// appendLedgerDeleted records that files were removed on purpose, so
+// -verify doesn't report them as missing.
+func appendLedgerDeleted(files ...string) error {
+	var entries []LedgerEntry
+	for _, file := range files {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, LedgerEntry{Path: abs, Deleted: true})
+	}
+	return writeLedger(entries)
+}
+
+// writeLedger chains entries onto the end of the ledger.
+func writeLedger(entries []LedgerEntry) error {
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+
+	path := ledgerPath()
+	existing, err := readLedger(path)
+	if err != nil {
+		return err
+	}
+	prev := ""
+	if len(existing) > 0 {
+		prev = existing[len(existing)-1].Hash
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for _, e := range entries {
+		e.Time = time.Now().UTC()
+		e.Prev = prev
+		e.Hash = entryHash(e)
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(append(data, '\n')); err != nil {
+			return fmt.Errorf("write ledger: %w", err)
+		}
+		prev = e.Hash
+	}
+	return f.Sync()
+}
+
+// uploadCopy matches the re-encoded and split copies made for notifiers with
+// upload limits, sealed or not. They are derived from a listed video, not
+// evidence.
+var uploadCopy = regexp.MustCompile(`_(small|part\d+)\.(mp4|avi|mkv|webm)(` + regexp.QuoteMeta(sealExt) + `)?$`)
+
+// runVerify checks the ledger chain and compares it with the videos
+// directory. It prints one line per problem to w and returns how many it
+// found.
+func runVerify(w io.Writer) (int, error) {
+	entries, err := readLedger(ledgerPath())
+	if err != nil {
+		return 0, err
+	}
+	dir, err := videosDir()
+	if err != nil {
+		return 0, err
+	}
+
+	problems := 0
+	report := func(format string, args ...any) {
+		fmt.Fprintf(w, format+"\n", args...)
+		problems++
+	}
+
+	listed := make(map[string]LedgerEntry)
+	var paths []string
+	prev := ""
+	for i, e := range entries {
+		if e.Prev != prev {
+			report("BROKEN CHAIN  entry %d (%s): previous hash does not match", i+1, e.Path)
+		}
+		if entryHash(e) != e.Hash {
+			report("BAD ENTRY     entry %d (%s): entry was altered", i+1, e.Path)
+		}
+		prev = e.Hash
+		if _, ok := listed[e.Path]; !ok {
+			paths = append(paths, e.Path)
+		}
+		listed[e.Path] = e
+	}
+
+	sort.Strings(paths)
+	for _, path := range paths {
+		e := listed[path]
+		if e.Deleted {
+			continue
+		}
+		sum, size, err := fileHash(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			report("MISSING       %s", path)
+		case err != nil:
+			report("UNREADABLE    %s: %v", path, err)
+		case sum != e.SHA256 || size != e.Size:
+			report("MODIFIED      %s", path)
+		}
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return problems, err
+	}
+	err = filepath.WalkDir(absDir, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, os.ErrNotExist) && path == absDir {
+			return fs.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || uploadCopy.MatchString(path) {
+			return nil
+		}
+		if e, ok := listed[path]; !ok || e.Deleted {
+			report("UNLISTED      %s", path)
+		}
+		return nil
+	})
+
+	fmt.Fprintf(w, "%d ledger entries, %d problem(s)\n", len(entries), problems)
+	return problems, err
+}
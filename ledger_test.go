@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// ledgerDir isolates the ledger and returns an empty videos directory.
+func ledgerDir(t *testing.T) string {
+	t.Helper()
+	isolateHome(t)
+	dir := t.TempDir()
+	useConfig(t, Config{OutputDir: dir})
+	return dir
+}
+
+// writeFiles creates each file in dir with its name as content.
+func writeFiles(t *testing.T, dir string, names ...string) []string {
+	t.Helper()
+	var paths []string
+	for _, name := range names {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// verify runs runVerify and returns its report lines.
+func verify(t *testing.T) (int, []string) {
+	t.Helper()
+	var out bytes.Buffer
+	problems, err := runVerify(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return problems, strings.Split(strings.TrimSpace(out.String()), "\n")
+}
+
+func TestLedgerChain(t *testing.T) {
+	dir := ledgerDir(t)
+	files := writeFiles(t, dir, "capture_1.mp4", "capture_1.jpg")
+	if err := appendLedger(files...); err != nil {
+		t.Fatal(err)
+	}
+	if err := appendLedgerDeleted(files[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := readLedger(ledgerPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("%d entries, want 3", len(entries))
+	}
+	prev := ""
+	for i, e := range entries {
+		if e.Prev != prev {
+			t.Errorf("entry %d: Prev = %q, want %q", i, e.Prev, prev)
+		}
+		if e.Hash != entryHash(e) {
+			t.Errorf("entry %d: Hash doesn't cover the entry", i)
+		}
+		if e.Time.IsZero() {
+			t.Errorf("entry %d: no time", i)
+		}
+		prev = e.Hash
+	}
+
+	sum := sha256.Sum256([]byte("capture_1.mp4"))
+	if e := entries[0]; e.Path != files[0] || e.SHA256 != hex.EncodeToString(sum[:]) || e.Size != int64(len("capture_1.mp4")) || e.Deleted {
+		t.Errorf("file entry = %+v", e)
+	}
+	if e := entries[2]; e.Path != files[1] || !e.Deleted || e.SHA256 != "" {
+		t.Errorf("deletion entry = %+v", e)
+	}
+}
+
+func TestVerifyClean(t *testing.T) {
+	dir := ledgerDir(t)
+	files := writeFiles(t, dir, "capture_1.mp4", "capture_1.jpg", "2025/capture_2.mp4"+sealExt)
+	if err := appendLedger(files...); err != nil {
+		t.Fatal(err)
+	}
+	// Upload copies are made from listed videos and not listed themselves.
+	writeFiles(t, dir, "capture_1_small.mp4", "2025/capture_2_part1.avi"+sealExt)
+
+	problems, lines := verify(t)
+	if problems != 0 || len(lines) != 1 || lines[0] != "3 ledger entries, 0 problem(s)" {
+		t.Errorf("verify() = %d:\n%s", problems, strings.Join(lines, "\n"))
+	}
+}
+
+func TestVerifyReportsFiles(t *testing.T) {
+	dir := ledgerDir(t)
+	files := writeFiles(t, dir, "a.mp4", "b.mp4", "c.mp4", "d.mp4")
+	if err := appendLedger(files...); err != nil {
+		t.Fatal(err)
+	}
+	a, b, c, d := files[0], files[1], files[2], files[3]
+
+	if err := os.WriteFile(a, []byte("edited"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(b)
+	// Removed by retention: not missing.
+	os.Remove(c)
+	if err := appendLedgerDeleted(c); err != nil {
+		t.Fatal(err)
+	}
+	// Same size, different content.
+	if err := os.WriteFile(d, []byte("D.mp4"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	e := writeFiles(t, dir, "e.mp4")[0]
+
+	problems, lines := verify(t)
+	want := []string{
+		"MODIFIED      " + a,
+		"MISSING       " + b,
+		"MODIFIED      " + d,
+		"UNLISTED      " + e,
+		"5 ledger entries, 4 problem(s)",
+	}
+	if problems != 4 || strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("verify() = %d:\n%s\nwant:\n%s", problems, strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+
+	// A deleted file that comes back wasn't put there by a recording.
+	writeFiles(t, dir, "c.mp4")
+	if _, lines := verify(t); !contains(lines, "UNLISTED      "+c) {
+		t.Errorf("restored deleted file not reported:\n%s", strings.Join(lines, "\n"))
+	}
+}
+
+func TestVerifyDetectsLedgerTampering(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(lines []string) []string
+		want []string // {a}, {b} and {c} stand for the files
+	}{
+		{
+			"edited entry",
+			func(lines []string) []string {
+				lines[1] = strings.Replace(lines[1], `"size":5`, `"size":6`, 1)
+				return lines
+			},
+			[]string{"BAD ENTRY     entry 2 ({b}): entry was altered", "MODIFIED      {b}"},
+		},
+		{
+			"deleted entry",
+			func(lines []string) []string {
+				return append(lines[:1:1], lines[2:]...)
+			},
+			[]string{"BROKEN CHAIN  entry 2 ({c}): previous hash does not match", "UNLISTED      {b}"},
+		},
+		{
+			"swapped entries",
+			func(lines []string) []string {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			},
+			[]string{"BROKEN CHAIN  entry 2 ({c})", "BROKEN CHAIN  entry 3 ({b})"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := ledgerDir(t)
+			files := writeFiles(t, dir, "a.mp4", "b.mp4", "c.mp4")
+			if err := appendLedger(files...); err != nil {
+				t.Fatal(err)
+			}
+			if problems, _ := verify(t); problems != 0 {
+				t.Fatalf("%d problems before tampering", problems)
+			}
+
+			data, err := os.ReadFile(ledgerPath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := tt.edit(strings.Split(strings.TrimSpace(string(data)), "\n"))
+			if err := os.WriteFile(ledgerPath(), []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			problems, got := verify(t)
+			if problems == 0 {
+				t.Fatal("tampering not detected")
+			}
+			names := strings.NewReplacer("{a}", files[0], "{b}", files[1], "{c}", files[2])
+			for _, w := range tt.want {
+				if w = names.Replace(w); !containsPrefix(got, w) {
+					t.Errorf("no line %q in:\n%s", w, strings.Join(got, "\n"))
+				}
+			}
+		})
+	}
+}
+
+func contains(lines []string, line string) bool {
+	for _, l := range lines {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPrefix(lines []string, prefix string) bool {
+	for _, l := range lines {
+		if strings.HasPrefix(l, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// LidSensor reports the current lid state. true=open, false=closed.
+type LidSensor interface {
+	LidOpen() (bool, error)
+}
+
+// IoregSensor reads AppleClamshellState from ioreg (macOS).
+type IoregSensor struct{}
+
+func (IoregSensor) LidOpen() (bool, error) {
+	cmd := exec.Command("ioreg", "-r", "-k", "AppleClamshellState", "-d", "1")
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return false, fmt.Errorf("failed to run ioreg: %w", err)
+	}
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.Contains(line, "AppleClamshellState") {
+			if strings.Contains(line, "= Yes") {
+				return false, nil
+			}
+			if strings.Contains(line, "= No") {
+				return true, nil
+			}
+		}
+	}
+	return false, fmt.Errorf("AppleClamshellState not found")
+}
+
+const defaultACPILidRoot = "/proc/acpi/button/lid"
+
+// ACPISensor reads <Root>/*/state as exposed by the Linux ACPI button driver,
+// e.g. "state:      open". Root defaults to /proc/acpi/button/lid.
+type ACPISensor struct {
+	Root string
+}
+
+func (s ACPISensor) LidOpen() (bool, error) {
+	root := s.Root
+	if root == "" {
+		root = defaultACPILidRoot
+	}
+	matches, err := filepath.Glob(filepath.Join(root, "*", "state"))
+	if err != nil {
+		return false, err
+	}
+	if len(matches) == 0 {
+		return false, fmt.Errorf("no lid state found under %s", root)
+	}
+
+	// Some machines expose more than one lid (LID, LID0); use the first readable one.
+	var lastErr error
+	for _, path := range matches {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		state := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(string(data)), "state:"))
+		switch state {
+		case "open":
+			return true, nil
+		case "closed":
+			return false, nil
+		default:
+			lastErr = fmt.Errorf("unexpected lid state %q in %s", state, path)
+		}
+	}
+	return false, lastErr
+}
+
+// newLidSensor builds the sensor named by config.LidSensor ("ioreg", "acpi",
+// "logind"), or picks one for the current platform when it is empty or "auto".
+func newLidSensor(cfg Config) (LidSensor, error) {
+	switch cfg.LidSensor {
+	case "ioreg":
+		return IoregSensor{}, nil
+	case "acpi":
+		return ACPISensor{Root: cfg.ACPILidRoot}, nil
+	case "logind":
+		return NewLogindSensor(cfg.DBusAddress)
+	case "", "auto":
+	default:
+		return nil, fmt.Errorf("unknown lid sensor %q", cfg.LidSensor)
+	}
+
+	if runtime.GOOS == "darwin" {
+		return IoregSensor{}, nil
+	}
+	// Prefer logind so transitions arrive as signals, but only if it actually
+	// knows about a lid.
+	if s, err := NewLogindSensor(cfg.DBusAddress); err == nil {
+		if _, err := s.LidOpen(); err == nil {
+			return s, nil
+		}
+		s.Close()
+	}
+	return ACPISensor{Root: cfg.ACPILidRoot}, nil
+}
+
+// closeLidSensor releases sensors that hold a connection.
+func closeLidSensor(sensor LidSensor) {
+	if c, ok := sensor.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// LidWatcher is implemented by sensors that push lid transitions instead of
+// having to be polled.
+type LidWatcher interface {
+	LidSensor
+	WatchLid(stop <-chan struct{}) (<-chan bool, error)
+}
+
+// lidWatchPoll is how often a watched sensor is polled anyway, in case a
+// transition signal is lost.
+var lidWatchPoll = 5 * time.Second
+
+// lidSamples streams lid states from sensor until stop is closed. Watchers
+// push their transitions directly and are also polled every lidWatchPoll;
+// everything else is polled every interval. If a watch ends early, e.g.
+// because D-Bus restarted, polling takes over. Read errors are skipped, like
+// the old polling loops did.
+func lidSamples(sensor LidSensor, interval time.Duration, stop <-chan struct{}) <-chan bool {
+	out := make(chan bool)
+
+	send := func(open bool) bool {
+		select {
+		case out <- open:
+			return true
+		case <-stop:
+			return false
+		}
+	}
+
+	go func() {
+		defer close(out)
+
+		var events <-chan bool
+		poll := interval
+		if w, ok := sensor.(LidWatcher); ok {
+			var err error
+			if events, err = w.WatchLid(stop); err != nil {
+				log.Printf("Lid watch failed, falling back to polling: %v", err)
+				events = nil
+			} else {
+				poll = max(interval, lidWatchPoll)
+				if open, err := sensor.LidOpen(); err == nil && !send(open) {
+					return
+				}
+			}
+		}
+
+		ticker := time.NewTicker(poll)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case open, ok := <-events:
+				if !ok {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					log.Printf("Lid watch ended, falling back to polling")
+					events = nil
+					ticker.Reset(interval)
+					continue
+				}
+				if !send(open) {
+					return
+				}
+			case <-ticker.C:
+				open, err := sensor.LidOpen()
+				if err != nil {
+					continue
+				}
+				if !send(open) {
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
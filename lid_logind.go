@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	login1Service   = "org.freedesktop.login1"
+	login1Path      = dbus.ObjectPath("/org/freedesktop/login1")
+	login1Manager   = "org.freedesktop.login1.Manager"
+	propertiesIface = "org.freedesktop.DBus.Properties"
+)
+
+// LogindSensor reads the LidClosed property of systemd-logind and watches
+// its PropertiesChanged signal, so lid transitions arrive without polling.
+// If the bus goes away, e.g. because D-Bus restarted, LidOpen reconnects.
+type LogindSensor struct {
+	address string
+
+	mu   sync.Mutex
+	conn *dbus.Conn
+}
+
+// NewLogindSensor connects to the bus at address, or to the system bus when
+// address is empty. Pointing it at a private bus with a stand-in login1
+// service is enough to exercise it without logind.
+func NewLogindSensor(address string) (*LogindSensor, error) {
+	s := &LogindSensor{address: address}
+	if _, err := s.bus(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// bus returns the connection, reconnecting if it was lost.
+func (s *LogindSensor) bus() (*dbus.Conn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil && s.conn.Connected() {
+		return s.conn, nil
+	}
+
+	var conn *dbus.Conn
+	var err error
+	if s.address == "" {
+		conn, err = dbus.ConnectSystemBus()
+	} else {
+		conn, err = dbus.Connect(s.address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connect to D-Bus: %w", err)
+	}
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.conn = conn
+	return conn, nil
+}
+
+func (s *LogindSensor) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+func (s *LogindSensor) LidOpen() (bool, error) {
+	conn, err := s.bus()
+	if err != nil {
+		return false, err
+	}
+	v, err := conn.Object(login1Service, login1Path).GetProperty(login1Manager + ".LidClosed")
+	if err != nil {
+		return false, fmt.Errorf("read LidClosed: %w", err)
+	}
+	closed, ok := v.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("LidClosed has unexpected type %s", v.Signature())
+	}
+	return !closed, nil
+}
+
+// WatchLid closes its channel when stop is closed or the connection is lost.
+func (s *LogindSensor) WatchLid(stop <-chan struct{}) (<-chan bool, error) {
+	conn, err := s.bus()
+	if err != nil {
+		return nil, err
+	}
+	match := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(login1Path),
+		dbus.WithMatchInterface(propertiesIface),
+		dbus.WithMatchMember("PropertiesChanged"),
+		dbus.WithMatchArg(0, login1Manager),
+	}
+	if err := conn.AddMatchSignal(match...); err != nil {
+		return nil, fmt.Errorf("subscribe to login1 PropertiesChanged: %w", err)
+	}
+
+	signals := make(chan *dbus.Signal, 16)
+	conn.Signal(signals)
+
+	out := make(chan bool)
+	go func() {
+		defer close(out)
+		defer conn.RemoveMatchSignal(match...)
+		defer conn.RemoveSignal(signals)
+
+		for {
+			select {
+			case <-stop:
+				return
+			case sig, ok := <-signals:
+				if !ok {
+					return
+				}
+				open, ok := s.lidFromSignal(sig)
+				if !ok {
+					continue
+				}
+				select {
+				case out <- open:
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// lidFromSignal extracts LidClosed from a PropertiesChanged signal. If the
+// property was only invalidated, it is read back from logind.
+func (s *LogindSensor) lidFromSignal(sig *dbus.Signal) (bool, bool) {
+	if sig.Path != login1Path || sig.Name != propertiesIface+".PropertiesChanged" || len(sig.Body) < 3 {
+		return false, false
+	}
+	if iface, _ := sig.Body[0].(string); iface != login1Manager {
+		return false, false
+	}
+
+	if changed, ok := sig.Body[1].(map[string]dbus.Variant); ok {
+		if v, ok := changed["LidClosed"]; ok {
+			closed, ok := v.Value().(bool)
+			return !closed, ok
+		}
+	}
+	if invalidated, ok := sig.Body[2].([]string); ok {
+		for _, name := range invalidated {
+			if name == "LidClosed" {
+				open, err := s.LidOpen()
+				return open, err == nil
+			}
+		}
+	}
+	return false, false
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const testBusConfig = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:dir=%s</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`
+
+// startPrivateBus runs a dbus-daemon of its own for the test and returns
+// its address. The test is skipped if dbus-daemon isn't installed.
+func startPrivateBus(t *testing.T) string {
+	t.Helper()
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not installed")
+	}
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "bus.conf")
+	if err := os.WriteFile(conf, []byte(strings.Replace(testBusConfig, "%s", dir, 1)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(daemon, "--config-file="+conf, "--nofork", "--print-address")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	address, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read dbus-daemon address: %v", err)
+	}
+	return strings.TrimSpace(address)
+}
+
+// fakeLogin1 stands in for systemd-logind: it owns org.freedesktop.login1
+// and serves LidClosed through org.freedesktop.DBus.Properties.
+type fakeLogin1 struct {
+	conn *dbus.Conn
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newFakeLogin1(t *testing.T, address string, closed bool) *fakeLogin1 {
+	t.Helper()
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	f := &fakeLogin1{conn: conn, closed: closed}
+	if err := conn.Export(f, login1Path, propertiesIface); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := conn.RequestName(login1Service, dbus.NameFlagDoNotQueue)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("request %s: %v (reply %d)", login1Service, err, reply)
+	}
+	return f
+}
+
+func (f *fakeLogin1) Get(iface, property string) (dbus.Variant, *dbus.Error) {
+	if iface != login1Manager || property != "LidClosed" {
+		return dbus.Variant{}, dbus.MakeFailedError(os.ErrNotExist)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return dbus.MakeVariant(f.closed), nil
+}
+
+func (f *fakeLogin1) GetAll(iface string) (map[string]dbus.Variant, *dbus.Error) {
+	v, err := f.Get(iface, "LidClosed")
+	if err != nil {
+		return nil, err
+	}
+	return map[string]dbus.Variant{"LidClosed": v}, nil
+}
+
+// setLid changes LidClosed, announcing it with PropertiesChanged if emit
+// is set.
+func (f *fakeLogin1) setLid(t *testing.T, closed, emit bool) {
+	t.Helper()
+	f.mu.Lock()
+	f.closed = closed
+	f.mu.Unlock()
+	if !emit {
+		return
+	}
+	err := f.conn.Emit(login1Path, propertiesIface+".PropertiesChanged",
+		login1Manager, map[string]dbus.Variant{"LidClosed": dbus.MakeVariant(closed)}, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLogindSensor(t *testing.T) {
+	address := startPrivateBus(t)
+	login1 := newFakeLogin1(t, address, false)
+
+	s, err := NewLogindSensor(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if open, err := s.LidOpen(); err != nil || !open {
+		t.Fatalf("LidOpen() = %v, %v; want true, nil", open, err)
+	}
+
+	shortWatchPoll(t)
+	stop := make(chan struct{})
+	defer close(stop)
+	samples := lidSamples(s, 10*time.Millisecond, stop)
+	waitSample(t, samples, true)
+
+	login1.setLid(t, true, true)
+	waitSample(t, samples, false)
+
+	// A change whose PropertiesChanged never arrives is still picked up.
+	login1.setLid(t, false, false)
+	waitSample(t, samples, true)
+}
+
+func TestLogindSensorSignalOnly(t *testing.T) {
+	address := startPrivateBus(t)
+	login1 := newFakeLogin1(t, address, false)
+
+	s, err := NewLogindSensor(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	events, err := s.WatchLid(stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login1.setLid(t, true, true)
+	select {
+	case open := <-events:
+		if open {
+			t.Error("got open, want closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no PropertiesChanged within 5s")
+	}
+}
+
+func TestLogindSensorConnectionLost(t *testing.T) {
+	address := startPrivateBus(t)
+	login1 := newFakeLogin1(t, address, false)
+
+	s, err := NewLogindSensor(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	samples := lidSamples(s, 10*time.Millisecond, stop)
+	waitSample(t, samples, true)
+
+	// As if D-Bus restarted: the watch ends, and polling reconnects.
+	s.mu.Lock()
+	s.conn.Close()
+	s.mu.Unlock()
+
+	login1.setLid(t, true, false)
+	waitSample(t, samples, false)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeLidState creates <root>/<lid>/state the way the ACPI button driver
+// lays it out.
+func writeLidState(t *testing.T, root, lid, state string) {
+	t.Helper()
+	dir := filepath.Join(root, lid)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "state"), []byte(state), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestACPISensor(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    string
+		wantOpen bool
+		wantErr  bool
+	}{
+		{"open", "state:      open\n", true, false},
+		{"closed", "state:      closed\n", false, false},
+		{"bare value", "closed", false, false},
+		{"garbage", "state:      unknown\n", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeLidState(t, root, "LID0", tt.state)
+
+			open, err := ACPISensor{Root: root}.LidOpen()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LidOpen() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && open != tt.wantOpen {
+				t.Errorf("LidOpen() = %v, want %v", open, tt.wantOpen)
+			}
+		})
+	}
+}
+
+func TestACPISensorSkipsUnreadableLid(t *testing.T) {
+	root := t.TempDir()
+	writeLidState(t, root, "LID", "state:      bogus\n")
+	writeLidState(t, root, "LID0", "state:      open\n")
+
+	open, err := ACPISensor{Root: root}.LidOpen()
+	if err != nil || !open {
+		t.Errorf("LidOpen() = %v, %v; want true, nil", open, err)
+	}
+}
+
+func TestACPISensorMissingDirectory(t *testing.T) {
+	for _, root := range []string{
+		filepath.Join(t.TempDir(), "does-not-exist"),
+		t.TempDir(), // exists, but has no lid in it
+	} {
+		if _, err := (ACPISensor{Root: root}).LidOpen(); err == nil {
+			t.Errorf("LidOpen() with root %s succeeded, want an error", root)
+		}
+	}
+}
+
+// fakeWatcher is a LidWatcher whose state and signals the test controls.
+type fakeWatcher struct {
+	mu     sync.Mutex
+	open   bool
+	events chan bool
+}
+
+func (w *fakeWatcher) LidOpen() (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.open, nil
+}
+
+func (w *fakeWatcher) WatchLid(stop <-chan struct{}) (<-chan bool, error) {
+	return w.events, nil
+}
+
+// set changes the lid state without signalling it.
+func (w *fakeWatcher) set(open bool) {
+	w.mu.Lock()
+	w.open = open
+	w.mu.Unlock()
+}
+
+// signal changes the lid state and pushes the transition.
+func (w *fakeWatcher) signal(open bool) {
+	w.set(open)
+	w.events <- open
+}
+
+// waitSample reads samples until one equals want.
+func waitSample(t *testing.T, samples <-chan bool, want bool) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case open, ok := <-samples:
+			if !ok {
+				t.Fatalf("samples closed while waiting for %v", want)
+			}
+			if open == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no sample %v within 5s", want)
+		}
+	}
+}
+
+func shortWatchPoll(t *testing.T) {
+	old := lidWatchPoll
+	lidWatchPoll = 10 * time.Millisecond
+	t.Cleanup(func() { lidWatchPoll = old })
+}
+
+func TestLidSamplesPollsWatcher(t *testing.T) {
+	shortWatchPoll(t)
+	w := &fakeWatcher{open: true, events: make(chan bool)}
+	stop := make(chan struct{})
+	defer close(stop)
+	samples := lidSamples(w, 10*time.Millisecond, stop)
+
+	waitSample(t, samples, true)
+	w.signal(false)
+	waitSample(t, samples, false)
+
+	// A transition whose signal never arrives is still seen.
+	w.set(true)
+	waitSample(t, samples, true)
+}
+
+func TestLidSamplesFallsBackWhenWatchEnds(t *testing.T) {
+	shortWatchPoll(t)
+	w := &fakeWatcher{open: true, events: make(chan bool)}
+	stop := make(chan struct{})
+	defer close(stop)
+	samples := lidSamples(w, 10*time.Millisecond, stop)
+
+	waitSample(t, samples, true)
+	close(w.events)
+	w.set(false)
+	waitSample(t, samples, false)
+	w.set(true)
+	waitSample(t, samples, true)
+}
+
+func TestLidSamplesStops(t *testing.T) {
+	w := &fakeWatcher{open: true, events: make(chan bool)}
+	stop := make(chan struct{})
+	samples := lidSamples(w, 10*time.Millisecond, stop)
+	waitSample(t, samples, true)
+	close(stop)
+
+	for {
+		select {
+		case _, ok := <-samples:
+			if !ok {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("samples not closed after stop")
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	defaultLiveFPS     = 10
+	defaultLiveQuality = 70
+	liveBoundary       = "iseeyougoframe"
+)
+
+// LiveView is the live preview. It never opens the camera itself: whoever
+// is reading it (pre-roll, a recording) offers each frame, and the newest
+// one is kept as a JPEG for the streams. Encoding only happens while
+// someone is watching, at most FPS times a second.
+//
+// A nil *LiveView is valid and ignores frames.
+type LiveView struct {
+	FPS     int
+	Quality int
+
+	mu      sync.Mutex
+	viewers int
+	frame   []byte
+	last    time.Time
+	updated chan struct{} // closed when frame changes
+}
+
+func newLiveView() *LiveView {
+	return &LiveView{FPS: defaultLiveFPS, Quality: defaultLiveQuality, updated: make(chan struct{})}
+}
+
+// Offer encodes img if a viewer is waiting for the next frame.
+func (l *LiveView) Offer(img gocv.Mat) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	due := l.viewers > 0 && time.Since(l.last) >= time.Second/time.Duration(max(l.FPS, 1))
+	if due {
+		l.last = time.Now()
+	}
+	l.mu.Unlock()
+	if !due {
+		return
+	}
+
+	buf, err := gocv.IMEncodeWithParams(gocv.JPEGFileExt, img, []int{gocv.IMWriteJpegQuality, l.Quality})
+	if err != nil {
+		return
+	}
+	jpeg := bytes.Clone(buf.GetBytes())
+	buf.Close()
+
+	l.mu.Lock()
+	l.frame = jpeg
+	close(l.updated)
+	l.updated = make(chan struct{})
+	l.mu.Unlock()
+}
+
+// Stream writes frames to w as multipart/x-mixed-replace until the client
+// goes away or stop is closed. The caller keeps the camera open meanwhile.
+func (l *LiveView) Stream(w http.ResponseWriter, r *http.Request, stop <-chan struct{}) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	l.mu.Lock()
+	l.viewers++
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.viewers--
+		if l.viewers == 0 {
+			l.frame = nil // don't show a stale frame to the next viewer
+		}
+		l.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+liveBoundary)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		l.mu.Lock()
+		frame, updated := l.frame, l.updated
+		l.mu.Unlock()
+
+		if frame != nil {
+			_, err := fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", liveBoundary, len(frame))
+			if err == nil {
+				_, err = w.Write(frame)
+			}
+			if err == nil {
+				_, err = fmt.Fprint(w, "\r\n")
+			}
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+
+		select {
+		case <-updated:
+		case <-r.Context().Done():
+			return
+		case <-stop:
+			return
+		}
+	}
+}
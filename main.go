@@ -1,29 +1,79 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"log"
+	"os"
 )
 
+func usage() {
+	fmt.Println("IseeYouGo - Laptop Lid Monitor")
+	fmt.Println("Records video when your laptop lid opens.")
+	fmt.Println("")
+	fmt.Println("Usage:")
+	fmt.Println("  iseeyougo [options]")
+	fmt.Println("  iseeyougo <command> [flags]")
+	fmt.Println("")
+	fmt.Println("Options:")
+	fmt.Println("  -gui     Launch with graphical user interface")
+	fmt.Println("  -cli     Launch with interactive command line interface")
+	fmt.Println("  -verify  Check saved recordings against the ledger")
+	fmt.Println("  -help    Show this help message")
+	fmt.Println("")
+	fmt.Println("Commands:")
+	fmt.Println("  list-cameras [-json]              List cameras and configured sources")
+	fmt.Println("  monitor [-camera N] [-duration 15s]  Record on every lid open, no prompts")
+	fmt.Println("  daemon [monitor flags] [-pid-file path]  monitor for service managers")
+	fmt.Println("  service install [-- daemon flags] | uninstall | status  Start daemon at login")
+	fmt.Println("  record [-camera N] [-duration 10s]   Record one clip now and send it")
+	fmt.Println("  snap [-camera N] [-send]          Save a still picture")
+	fmt.Println("  test-notify                       Send a test message to every notifier")
+	fmt.Println("  config show | set <key> <value>   Print or edit the configuration")
+	fmt.Println("  verify                            Same as -verify")
+	fmt.Println("  keygen [-out file.key]            Create a key pair for encryption")
+	fmt.Println("  decrypt [-key file.key] file.age... Decrypt recordings")
+	fmt.Println("")
+	fmt.Println("Run a command with -h to see its flags. monitor, record and snap")
+	fmt.Println("flags override the config file for that run.")
+	fmt.Println("")
+	fmt.Println("If no option is specified, GUI mode is used by default.")
+}
+
 func main() {
-	useCLI := flag.Bool("cli", false, "Launch with command line interface")
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				if !errors.Is(err, errProblems) {
+					log.Print(err)
+				}
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	useCLI := flag.Bool("cli", false, "Launch with interactive command line interface")
+	flag.Bool("gui", false, "Launch with graphical user interface (default)")
 	showHelp := flag.Bool("help", false, "Show help message")
+	verify := flag.Bool("verify", false, "Check saved recordings against the ledger")
+	flag.Usage = usage
 
 	flag.Parse()
 
 	if *showHelp {
-		fmt.Println("IseeYouGo - Laptop Lid Monitor")
-		fmt.Println("Records video when your MacBook lid opens.")
-		fmt.Println("")
-		fmt.Println("Usage:")
-		fmt.Println("  iseeyougo [options]")
-		fmt.Println("")
-		fmt.Println("Options:")
-		fmt.Println("  -gui     Launch with graphical user interface")
-		fmt.Println("  -cli     Launch with command line interface")
-		fmt.Println("  -help    Show this help message")
-		fmt.Println("")
-		fmt.Println("If no option is specified, GUI mode is used by default.")
+		usage()
+		return
+	}
+
+	if *verify {
+		if err := runVerifyCommand(nil); err != nil {
+			if !errors.Is(err, errProblems) {
+				log.Print(err)
+			}
+			os.Exit(1)
+		}
 		return
 	}
 
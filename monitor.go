@@ -0,0 +1,279 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// MonitorState is the trigger state of a Monitor.
+type MonitorState int
+
+const (
+	// Disarmed: the lid is open and nothing will fire until it closes.
+	Disarmed MonitorState = iota
+	// Armed: the lid was seen closed; the next open fires a trigger.
+	Armed
+	// Triggered: the lid opened while armed. Only seen on trigger events,
+	// the monitor moves on to Cooldown right away.
+	Triggered
+	// Cooldown: a trigger fired less than Cooldown ago. The monitor goes
+	// back to Disarmed when it runs out, or to Armed if the lid closes.
+	Cooldown
+)
+
+func (s MonitorState) String() string {
+	switch s {
+	case Disarmed:
+		return "disarmed"
+	case Armed:
+		return "armed"
+	case Triggered:
+		return "triggered"
+	case Cooldown:
+		return "cooldown"
+	}
+	return "unknown"
+}
+
+type MonitorEventKind int
+
+const (
+	// EventState reports a state change, including the initial state.
+	EventState MonitorEventKind = iota
+	// EventTrigger means the lid opened while armed: start recording.
+	EventTrigger
+	// EventCooldownRejected means the lid opened while armed, but too soon
+	// after the previous trigger.
+	EventCooldownRejected
+)
+
+type MonitorEvent struct {
+	Kind    MonitorEventKind
+	State   MonitorState
+	LidOpen bool
+	Paused  bool
+	Time    time.Time
+}
+
+// Monitor turns lid samples into trigger events. The CLI and the GUI both
+// run one and only decide how to present its events.
+type Monitor struct {
+	Cooldown     time.Duration
+	PollInterval time.Duration
+	// Now is the clock used for cooldowns; tests can replace it.
+	Now func() time.Time
+	// OnEvent, if set, sees every event as it is published, in order.
+	OnEvent func(MonitorEvent)
+
+	sensor LidSensor
+	events chan MonitorEvent
+
+	// sendMu keeps each step's events together and in order on events.
+	sendMu sync.Mutex
+	closed bool
+
+	mu          sync.Mutex
+	state       MonitorState
+	started     bool
+	paused      bool
+	lidOpen     bool
+	lastTrigger time.Time
+}
+
+func NewMonitor(sensor LidSensor) *Monitor {
+	return &Monitor{
+		Cooldown:     5 * time.Second,
+		PollInterval: 500 * time.Millisecond,
+		Now:          time.Now,
+		sensor:       sensor,
+		events:       make(chan MonitorEvent, 16),
+	}
+}
+
+// Events is closed when Run returns.
+func (m *Monitor) Events() <-chan MonitorEvent {
+	return m.events
+}
+
+func (m *Monitor) State() MonitorState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.state == Cooldown && !m.inCooldown(m.Now()) {
+		return Disarmed
+	}
+	return m.state
+}
+
+func (m *Monitor) Paused() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.paused
+}
+
+// LastTrigger is zero until the first trigger.
+func (m *Monitor) LastTrigger() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastTrigger
+}
+
+// Run feeds sensor samples through the state machine until stop is closed.
+// A cooldown ends on time even if no sample arrives, which with a watched
+// sensor can take as long as the lid stays untouched.
+func (m *Monitor) Run(stop <-chan struct{}) {
+	defer func() {
+		m.sendMu.Lock()
+		m.closed = true
+		close(m.events)
+		m.sendMu.Unlock()
+	}()
+
+	samples := lidSamples(m.sensor, m.PollInterval, stop)
+	var expire <-chan time.Time
+	for {
+		var step func() []MonitorEvent
+		select {
+		case open, ok := <-samples:
+			if !ok {
+				return
+			}
+			step = func() []MonitorEvent { return m.Observe(open) }
+		case <-expire:
+			step = m.expireCooldown
+		}
+		if !m.publish(step, stop) {
+			return
+		}
+
+		expire = nil
+		if left, ok := m.cooldownLeft(); ok {
+			expire = time.After(left)
+		}
+	}
+}
+
+// SetPaused stops or resumes arming, e.g. for a remote disarm. A paused
+// monitor stays Disarmed whatever the lid does.
+func (m *Monitor) SetPaused(paused bool) {
+	m.publish(func() []MonitorEvent { return m.setPaused(paused) }, nil)
+}
+
+// publish runs step and delivers its events. It reports false once the
+// monitor is shut down or stop is closed.
+func (m *Monitor) publish(step func() []MonitorEvent, stop <-chan struct{}) bool {
+	m.sendMu.Lock()
+	defer m.sendMu.Unlock()
+
+	events := step()
+	if m.closed {
+		return false
+	}
+	for _, ev := range events {
+		if m.OnEvent != nil {
+			m.OnEvent(ev)
+		}
+		select {
+		case m.events <- ev:
+		case <-stop:
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Monitor) setPaused(paused bool) []MonitorEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.paused == paused {
+		return nil
+	}
+	m.paused = paused
+	m.state = Disarmed
+	if !paused && m.started && !m.lidOpen {
+		m.state = Armed
+	}
+	return []MonitorEvent{{Kind: EventState, State: m.state, LidOpen: m.lidOpen, Paused: paused, Time: m.Now()}}
+}
+
+// Observe advances the state machine by one lid sample and returns the
+// resulting events.
+func (m *Monitor) Observe(open bool) []MonitorEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.Now()
+	m.lidOpen = open
+	var events []MonitorEvent
+	emit := func(kind MonitorEventKind, state MonitorState) {
+		events = append(events, MonitorEvent{Kind: kind, State: state, LidOpen: open, Paused: m.paused, Time: now})
+	}
+	setState := func(state MonitorState) {
+		if state != m.state {
+			m.state = state
+			emit(EventState, state)
+		}
+	}
+
+	if !m.started {
+		m.started = true
+		m.state = Disarmed
+		if !open && !m.paused {
+			m.state = Armed
+		}
+		emit(EventState, m.state)
+		return events
+	}
+
+	if m.paused {
+		return events
+	}
+
+	if m.state == Cooldown && !m.inCooldown(now) {
+		setState(Disarmed)
+	}
+
+	switch {
+	case !open:
+		// Closing the lid always arms, even during cooldown; the cooldown is
+		// checked again when it opens.
+		setState(Armed)
+	case m.state == Armed && m.inCooldown(now):
+		emit(EventCooldownRejected, Cooldown)
+		setState(Cooldown)
+	case m.state == Armed:
+		m.lastTrigger = now
+		emit(EventTrigger, Triggered)
+		m.state = Triggered
+		setState(Cooldown)
+	}
+
+	return events
+}
+
+// expireCooldown leaves Cooldown once it has run out.
+func (m *Monitor) expireCooldown() []MonitorEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.Now()
+	if m.state != Cooldown || m.inCooldown(now) {
+		return nil
+	}
+	m.state = Disarmed
+	return []MonitorEvent{{Kind: EventState, State: m.state, LidOpen: m.lidOpen, Paused: m.paused, Time: now}}
+}
+
+// cooldownLeft reports how long the current cooldown still runs.
+func (m *Monitor) cooldownLeft() (time.Duration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.state != Cooldown {
+		return 0, false
+	}
+	return max(m.lastTrigger.Add(m.Cooldown).Sub(m.Now()), 0), true
+}
+
+func (m *Monitor) inCooldown(now time.Time) bool {
+	return !m.lastTrigger.IsZero() && now.Sub(m.lastTrigger) < m.Cooldown
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Monitor.Now that only moves when told to.
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+// describe turns events into short strings such as "state:armed",
+// "trigger" and "rejected" for comparison.
+func describe(events []MonitorEvent) []string {
+	out := []string{}
+	for _, ev := range events {
+		switch ev.Kind {
+		case EventState:
+			out = append(out, "state:"+ev.State.String())
+		case EventTrigger:
+			out = append(out, "trigger")
+		case EventCooldownRejected:
+			out = append(out, "rejected")
+		default:
+			out = append(out, fmt.Sprintf("kind:%d", ev.Kind))
+		}
+	}
+	return out
+}
+
+func TestMonitorObserve(t *testing.T) {
+	const cooldown = 5 * time.Second
+
+	type step struct {
+		after time.Duration // clock advance before the sample
+		open  bool
+		want  []string
+	}
+	tests := []struct {
+		name  string
+		steps []step
+		state MonitorState // after the last step
+	}{
+		{
+			name:  "starts open",
+			steps: []step{{0, true, []string{"state:disarmed"}}},
+			state: Disarmed,
+		},
+		{
+			name:  "starts closed",
+			steps: []step{{0, false, []string{"state:armed"}}},
+			state: Armed,
+		},
+		{
+			name: "open while armed triggers",
+			steps: []step{
+				{0, true, []string{"state:disarmed"}},
+				{time.Second, false, []string{"state:armed"}},
+				{time.Second, true, []string{"trigger", "state:cooldown"}},
+			},
+			state: Cooldown,
+		},
+		{
+			name: "repeated samples are quiet",
+			steps: []step{
+				{0, false, []string{"state:armed"}},
+				{time.Second, false, []string{}},
+				{time.Second, false, []string{}},
+				{time.Second, true, []string{"trigger", "state:cooldown"}},
+				{time.Second, true, []string{}},
+			},
+			state: Cooldown,
+		},
+		{
+			name: "flapping within the cooldown",
+			steps: []step{
+				{0, false, []string{"state:armed"}},
+				{time.Second, true, []string{"trigger", "state:cooldown"}},
+				{100 * time.Millisecond, false, []string{"state:armed"}},
+				{100 * time.Millisecond, true, []string{"rejected", "state:cooldown"}},
+				{100 * time.Millisecond, false, []string{"state:armed"}},
+				{100 * time.Millisecond, true, []string{"rejected", "state:cooldown"}},
+			},
+			state: Cooldown,
+		},
+		{
+			name: "reopen just before the cooldown ends",
+			steps: []step{
+				{0, false, []string{"state:armed"}},
+				{0, true, []string{"trigger", "state:cooldown"}},
+				{time.Second, false, []string{"state:armed"}},
+				{cooldown - time.Second - time.Nanosecond, true, []string{"rejected", "state:cooldown"}},
+			},
+			state: Cooldown,
+		},
+		{
+			name: "reopen exactly when the cooldown ends",
+			steps: []step{
+				{0, false, []string{"state:armed"}},
+				{0, true, []string{"trigger", "state:cooldown"}},
+				{time.Second, false, []string{"state:armed"}},
+				{cooldown - time.Second, true, []string{"trigger", "state:cooldown"}},
+			},
+			state: Cooldown,
+		},
+		{
+			name: "cooldown runs out while open",
+			steps: []step{
+				{0, false, []string{"state:armed"}},
+				{0, true, []string{"trigger", "state:cooldown"}},
+				{cooldown, true, []string{"state:disarmed"}},
+			},
+			state: Disarmed,
+		},
+		{
+			name: "cooldown runs out while closed",
+			steps: []step{
+				{0, false, []string{"state:armed"}},
+				{0, true, []string{"trigger", "state:cooldown"}},
+				{time.Second, false, []string{"state:armed"}},
+				{2 * cooldown, true, []string{"trigger", "state:cooldown"}},
+			},
+			state: Cooldown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+			m := NewMonitor(nil)
+			m.Cooldown = cooldown
+			m.Now = clock.Now
+
+			for i, s := range tt.steps {
+				clock.now = clock.now.Add(s.after)
+				got := describe(m.Observe(s.open))
+				if !reflect.DeepEqual(got, s.want) {
+					t.Fatalf("step %d (open=%v): events %v, want %v", i, s.open, got, s.want)
+				}
+			}
+			if got := m.State(); got != tt.state {
+				t.Errorf("State() = %v, want %v", got, tt.state)
+			}
+		})
+	}
+}
+
+func TestMonitorStateAfterCooldown(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	m := NewMonitor(nil)
+	m.Now = clock.Now
+
+	m.Observe(false)
+	m.Observe(true)
+	if got := m.State(); got != Cooldown {
+		t.Fatalf("State() = %v right after the trigger, want cooldown", got)
+	}
+	clock.now = clock.now.Add(m.Cooldown)
+	if got := m.State(); got != Disarmed {
+		t.Errorf("State() = %v once the cooldown is over, want disarmed", got)
+	}
+}
+
+func TestMonitorPaused(t *testing.T) {
+	m := NewMonitor(nil)
+
+	if got := describe(m.Observe(false)); !reflect.DeepEqual(got, []string{"state:armed"}) {
+		t.Fatalf("first sample: %v", got)
+	}
+	if got := describe(m.setPaused(true)); !reflect.DeepEqual(got, []string{"state:disarmed"}) {
+		t.Fatalf("pause: %v", got)
+	}
+	for _, open := range []bool{true, false, true} {
+		if got := m.Observe(open); len(got) != 0 {
+			t.Fatalf("paused monitor reacted to open=%v: %v", open, describe(got))
+		}
+	}
+	// The lid was last seen open, so resuming doesn't arm.
+	if got := describe(m.setPaused(false)); !reflect.DeepEqual(got, []string{"state:disarmed"}) {
+		t.Fatalf("resume: %v", got)
+	}
+	m.Observe(false)
+	if got := describe(m.Observe(true)); !reflect.DeepEqual(got, []string{"trigger", "state:cooldown"}) {
+		t.Errorf("open after resume: %v", got)
+	}
+}
+
+// TestMonitorRunEndsCooldown checks that a watched sensor that goes quiet
+// after a trigger doesn't leave the monitor in Cooldown.
+func TestMonitorRunEndsCooldown(t *testing.T) {
+	w := &fakeWatcher{open: false, events: make(chan bool)}
+	m := NewMonitor(w)
+	m.Cooldown = 50 * time.Millisecond
+	stop := make(chan struct{})
+	defer close(stop)
+	go m.Run(stop)
+
+	var got []string
+	timeout := time.After(5 * time.Second)
+	for len(got) < 4 {
+		select {
+		case ev := <-m.Events():
+			got = append(got, describe([]MonitorEvent{ev})...)
+			if len(got) == 1 {
+				go w.signal(true)
+			}
+		case <-timeout:
+			t.Fatalf("events so far %v, want the cooldown to end", got)
+		}
+	}
+	want := []string{"state:armed", "trigger", "state:cooldown", "state:disarmed"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events %v, want %v", got, want)
+	}
+	if s := m.State(); s != Disarmed {
+		t.Errorf("State() = %v, want disarmed", s)
+	}
+}
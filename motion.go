@@ -0,0 +1,94 @@
+package main
+
+import (
+	"image"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	defaultMotionThreshold = 0.01
+	defaultMotionIdle      = 5 // seconds
+	// Per-pixel change (0-255) that counts as motion; below it is sensor noise.
+	motionPixelDelta = 25
+	motionWidth      = 160
+)
+
+// MotionGate makes a recording run past its minimum length for as long as
+// there is motion: it stops after Idle without motion, or at Max.
+type MotionGate struct {
+	Max  time.Duration
+	Idle time.Duration
+	// Threshold is the fraction of pixels that must change between frames
+	// to count as motion (default 0.01).
+	Threshold float64
+}
+
+// motionGateFromConfig returns nil unless cfg enables motion-gated recording.
+func motionGateFromConfig(cfg Config) *MotionGate {
+	if cfg.MotionMaxSeconds <= 0 {
+		return nil
+	}
+	idle := cfg.MotionIdleSeconds
+	if idle <= 0 {
+		idle = defaultMotionIdle
+	}
+	return &MotionGate{
+		Max:       time.Duration(cfg.MotionMaxSeconds) * time.Second,
+		Idle:      time.Duration(idle) * time.Second,
+		Threshold: cfg.MotionThreshold,
+	}
+}
+
+// motionDetector compares each frame with the previous one on a small,
+// blurred grayscale copy.
+type motionDetector struct {
+	threshold float64
+	gray      gocv.Mat
+	small     gocv.Mat
+	prev      gocv.Mat
+	diff      gocv.Mat
+}
+
+func newMotionDetector(threshold float64) *motionDetector {
+	if threshold <= 0 {
+		threshold = defaultMotionThreshold
+	}
+	return &motionDetector{
+		threshold: threshold,
+		gray:      gocv.NewMat(),
+		small:     gocv.NewMat(),
+		prev:      gocv.NewMat(),
+		diff:      gocv.NewMat(),
+	}
+}
+
+// Moving reports whether img differs enough from the previous frame.
+func (d *motionDetector) Moving(img gocv.Mat) bool {
+	if err := gocv.CvtColor(img, &d.gray, gocv.ColorBGRToGray); err != nil {
+		return false
+	}
+	h := d.gray.Rows() * motionWidth / d.gray.Cols()
+	gocv.Resize(d.gray, &d.small, image.Pt(motionWidth, h), 0, 0, gocv.InterpolationArea)
+	gocv.GaussianBlur(d.small, &d.small, image.Pt(5, 5), 0, 0, gocv.BorderDefault)
+
+	if d.prev.Empty() {
+		d.small.CopyTo(&d.prev)
+		return false
+	}
+
+	gocv.AbsDiff(d.small, d.prev, &d.diff)
+	gocv.Threshold(d.diff, &d.diff, motionPixelDelta, 255, gocv.ThresholdBinary)
+	changed := float64(gocv.CountNonZero(d.diff)) / float64(d.diff.Total())
+	d.small.CopyTo(&d.prev)
+
+	return changed >= d.threshold
+}
+
+func (d *motionDetector) Close() {
+	d.gray.Close()
+	d.small.Close()
+	d.prev.Close()
+	d.diff.Close()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+// patternFrames reads n frames of a w x h test pattern.
+func patternFrames(t *testing.T, w, h, n int) []gocv.Mat {
+	t.Helper()
+	src, _, _, _, err := openSource(Device{Id: -1, Source: fmt.Sprintf("test:pattern?size=%dx%d", w, h)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	var frames []gocv.Mat
+	t.Cleanup(func() { closeMats(frames) })
+	for i := 0; i < n; i++ {
+		img := gocv.NewMat()
+		frames = append(frames, img)
+		if !src.Read(&img) {
+			t.Fatal("test pattern read failed")
+		}
+	}
+	return frames
+}
+
+func TestMotionDetector(t *testing.T) {
+	// The bar enters from the left; by frame 4 it is fully in view.
+	frames := patternFrames(t, 320, 240, 6)[4:]
+	d := newMotionDetector(0)
+	defer d.Close()
+
+	if d.Moving(frames[0]) {
+		t.Error("first frame counted as motion")
+	}
+	if d.Moving(frames[0]) {
+		t.Error("still frame counted as motion")
+	}
+	// The bar moves 8 pixels a frame.
+	if !d.Moving(frames[1]) {
+		t.Error("moving bar not detected")
+	}
+	if d.Moving(frames[1]) {
+		t.Error("frame compared with an older one than the previous")
+	}
+}
+
+func TestMotionDetectorIgnoresNoise(t *testing.T) {
+	dark := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(40, 40, 40, 0), 240, 320, gocv.MatTypeCV8UC3)
+	defer dark.Close()
+	// Below motionPixelDelta everywhere, like sensor noise or a slow
+	// change of light.
+	dimmer := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(50, 50, 50, 0), 240, 320, gocv.MatTypeCV8UC3)
+	defer dimmer.Close()
+	lit := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(200, 200, 200, 0), 240, 320, gocv.MatTypeCV8UC3)
+	defer lit.Close()
+
+	d := newMotionDetector(0)
+	defer d.Close()
+	d.Moving(dark)
+	if d.Moving(dimmer) {
+		t.Error("small change of brightness counted as motion")
+	}
+	if !d.Moving(lit) {
+		t.Error("light switched on not detected")
+	}
+}
+
+func TestMotionDetectorThreshold(t *testing.T) {
+	frames := patternFrames(t, 320, 240, 6)[4:]
+	// The bar's edges are a few percent of the frame.
+	d := newMotionDetector(0.5)
+	defer d.Close()
+	d.Moving(frames[0])
+	if d.Moving(frames[1]) {
+		t.Error("moving bar counted as motion over a 50% threshold")
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultFilenameTemplate = "capture_{time:20060102_150405}"
+	defaultTimeLayout       = "20060102_150405"
+)
+
+// NameFields fill in a filename template.
+type NameFields struct {
+	Time    time.Time
+	Host    string
+	Camera  string
+	Trigger string
+}
+
+var placeholder = regexp.MustCompile(`\{(time|host|camera|trigger|seq)(?::([^}]*))?\}`)
+
+// unsafeName matches characters that don't belong in a file name.
+var unsafeName = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func sanitizeName(s string) string {
+	return strings.Trim(unsafeName.ReplaceAllString(s, "-"), "-.")
+}
+
+// cameraName is a short, file-safe name for d, e.g. "camera0" or "clip".
+func cameraName(d Device) string {
+	if d.Source == "" {
+		return fmt.Sprintf("camera%d", d.Id)
+	}
+	name := strings.TrimSuffix(d.Source, "/")
+	if i := strings.IndexAny(name, "?#"); i > 0 {
+		name = name[:i]
+	}
+	name = filepath.Base(name)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	if name = sanitizeName(name); name == "" {
+		return "source"
+	}
+	return name
+}
+
+// expandTemplate substitutes the placeholders in tmpl:
+//
+//	{time}, {time:layout}   recording start, Go time layout (default 20060102_150405)
+//	{host}                  host name
+//	{camera}                camera index or source name
+//	{trigger}               lid, clip, ...
+//	{seq}                   1, 2, ... picking the first unused name
+//
+// Slashes in tmpl create subdirectories; the values themselves can't.
+func expandTemplate(tmpl string, f NameFields, seq int) string {
+	return placeholder.ReplaceAllStringFunc(tmpl, func(m string) string {
+		parts := placeholder.FindStringSubmatch(m)
+		switch parts[1] {
+		case "time":
+			layout := parts[2]
+			if layout == "" {
+				layout = defaultTimeLayout
+			}
+			return f.Time.Format(layout)
+		case "host":
+			return sanitizeName(f.Host)
+		case "camera":
+			return sanitizeName(f.Camera)
+		case "trigger":
+			return sanitizeName(f.Trigger)
+		case "seq":
+			return strconv.Itoa(seq)
+		}
+		return m
+	})
+}
+
+// reserveOutput creates an empty dir/<template>ext that nothing else has
+// claimed and returns its path. If the name is taken, {seq} counts up, or
+// without {seq} a _2, _3, ... suffix is added, so two recordings in the same
+// second don't overwrite each other. The file is held from retention (see
+// holdFile) until release is called.
+func reserveOutput(dir, tmpl string, f NameFields, ext string) (path string, release func(), err error) {
+	if tmpl == "" {
+		tmpl = defaultFilenameTemplate
+	}
+	hasSeq := strings.Contains(tmpl, "{seq}")
+	for n := 1; n < 10000; n++ {
+		name := expandTemplate(tmpl, f, n)
+		if !hasSeq && n > 1 {
+			name += fmt.Sprintf("_%d", n)
+		}
+		path = filepath.Join(dir, filepath.FromSlash(name)+ext)
+		if !strings.HasPrefix(path, filepath.Clean(dir)+string(filepath.Separator)) {
+			return "", nil, fmt.Errorf("filename template %q leaves %s", tmpl, dir)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return "", nil, err
+		}
+		if nameTaken(path) {
+			continue
+		}
+		// Held before it exists, so a sweep that lists it also sees the hold.
+		release = holdFile(path)
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, os.ErrExist) {
+			release()
+			continue
+		}
+		if err != nil {
+			release()
+			return "", nil, err
+		}
+		file.Close()
+		return path, release, nil
+	}
+	return "", nil, fmt.Errorf("no free file name for template %q in %s", tmpl, dir)
+}
+
+// nameTaken reports whether anything else shares path's base name, such as
+// an encrypted recording or one in another container. The snapshot, sidecar
+// and face crops are named after the base, so they would collide.
+func nameTaken(path string) bool {
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	for _, e := range entries {
+		name := e.Name()
+		if i := strings.Index(name, "."); i > 0 {
+			name = name[:i]
+		}
+		if derivedSuffix.ReplaceAllString(name, "") == base {
+			return true
+		}
+	}
+	return false
+}
+
+// hostName is used in file names and the overlay.
+func hostName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return host
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	f := NameFields{
+		Time:    time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC),
+		Host:    "Jo's laptop",
+		Camera:  "camera0",
+		Trigger: "lid",
+	}
+	tests := []struct {
+		tmpl string
+		seq  int
+		want string
+	}{
+		{defaultFilenameTemplate, 1, "capture_20250102_150405"},
+		{"{time}", 1, "20250102_150405"},
+		{"{time:2006/01/02}/{time:150405}", 1, "2025/01/02/150405"},
+		{"{host}_{camera}_{trigger}", 1, "Jo-s-laptop_camera0_lid"},
+		{"clip_{seq}", 3, "clip_3"},
+		{"{nope}_{time:}", 1, "{nope}_20250102_150405"},
+		{"plain", 2, "plain"},
+	}
+	for _, tt := range tests {
+		if got := expandTemplate(tt.tmpl, f, tt.seq); got != tt.want {
+			t.Errorf("expandTemplate(%q, %d) = %q, want %q", tt.tmpl, tt.seq, got, tt.want)
+		}
+	}
+}
+
+func TestExpandTemplateValuesStayInName(t *testing.T) {
+	f := NameFields{Host: "../../etc", Camera: "a/b", Trigger: `..\x`}
+	got := expandTemplate("{host}_{camera}_{trigger}", f, 1)
+	if strings.ContainsAny(got, `/\`) || strings.Contains(got, "..") {
+		t.Errorf("values made a path: %q", got)
+	}
+}
+
+func TestReserveOutput(t *testing.T) {
+	f := NameFields{Time: time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC), Trigger: "lid"}
+	tests := []struct {
+		name     string
+		tmpl     string
+		existing []string // relative to the output directory
+		want     string
+	}{
+		{"free", "capture_{trigger}", nil, "capture_lid.mp4"},
+		{"default template", "", nil, "capture_20250102_150405.mp4"},
+		{"taken adds _2", "capture", []string{"capture.mp4"}, "capture_2.mp4"},
+		{"taken twice adds _3", "capture", []string{"capture.mp4", "capture_2.mp4"}, "capture_3.mp4"},
+		{"seq counts up", "clip_{seq}", []string{"clip_1.mp4", "clip_2.avi"}, "clip_3.mp4"},
+		{"seq takes the first free", "clip_{seq}", []string{"clip_2.mp4"}, "clip_1.mp4"},
+		{"other container", "capture", []string{"capture.avi"}, "capture_2.mp4"},
+		{"sealed recording", "capture", []string{"capture.mp4" + sealExt}, "capture_2.mp4"},
+		{"sidecar", "capture", []string{"capture.json"}, "capture_2.mp4"},
+		{"snapshot", "capture", []string{"capture.jpg"}, "capture_2.mp4"},
+		{"face crops", "capture", []string{"capture_faces/frame00000_0.jpg"}, "capture_2.mp4"},
+		{"upload copy", "capture", []string{"capture_small.mp4"}, "capture_2.mp4"},
+		{"longer name isn't a clash", "capture", []string{"capture_2.mp4", "capture_lid.mp4"}, "capture.mp4"},
+		{"subdirectory", "{time:2006}/{trigger}", nil, "2025/lid.mp4"},
+		{"taken in subdirectory", "{time:2006}/{trigger}", []string{"2025/lid.mp4" + sealExt}, "2025/lid_2.mp4"},
+		{"dot dot inside", "a/../capture", nil, "capture.mp4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, e := range tt.existing {
+				path := filepath.Join(dir, filepath.FromSlash(e))
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			path, release, err := reserveOutput(dir, tt.tmpl, f, ".mp4")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer release()
+			if want := filepath.Join(dir, filepath.FromSlash(tt.want)); path != want {
+				t.Errorf("reserved %s, want %s", path, want)
+			}
+			if fi, err := os.Stat(path); err != nil || fi.Size() != 0 {
+				t.Errorf("reserved file: %v, %v", fi, err)
+			}
+		})
+	}
+}
+
+func TestReserveOutputStaysInDir(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "videos")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, tmpl := range []string{"../capture", "a/../../capture", "../videos2/capture", "{trigger}/../../capture"} {
+		path, _, err := reserveOutput(dir, tmpl, NameFields{Trigger: "lid"}, ".mp4")
+		if err == nil {
+			t.Errorf("template %q reserved %s", tmpl, path)
+		}
+	}
+	if entries, _ := os.ReadDir(parent); len(entries) != 1 {
+		t.Errorf("files created next to the output directory: %v", entries)
+	}
+
+	// Values can't add path elements even with a template made of them.
+	path, release, err := reserveOutput(dir, "{host}", NameFields{Host: "../../x"}, ".mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if filepath.Dir(path) != dir {
+		t.Errorf("reserved %s outside %s", path, dir)
+	}
+}
+
+func TestReserveOutputConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	paths := make(chan string, 20)
+	for i := 0; i < cap(paths); i++ {
+		go func() {
+			path, release, err := reserveOutput(dir, "capture", NameFields{}, ".mp4")
+			if err != nil {
+				t.Error(err)
+			} else {
+				release()
+			}
+			paths <- path
+		}()
+	}
+	seen := map[string]bool{}
+	for i := 0; i < cap(paths); i++ {
+		p := <-paths
+		if seen[p] {
+			t.Errorf("%s reserved twice", p)
+		}
+		seen[p] = true
+	}
+}
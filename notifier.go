@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Delivery is the outcome of one message sent through one notifier.
+type Delivery struct {
+	Notifier  string
+	MessageID string
+	Sent      time.Time
+	Err       error
+}
+
+// Notifier delivers recordings somewhere off the laptop.
+type Notifier interface {
+	Name() string
+	SendText(ctx context.Context, text string) (Delivery, error)
+	SendVideo(ctx context.Context, path, caption string) (Delivery, error)
+	SendPhoto(ctx context.Context, path, caption string) (Delivery, error)
+}
+
+// NotifierConfig configures one entry of Config.Notifiers.
+type NotifierConfig struct {
+	Type string `json:"type"` // "telegram"
+	Name string `json:"name,omitempty"`
+
+	BotToken string `json:"bot_token,omitempty"`
+	ChatID   int64  `json:"chat_id,omitempty"`
+	// APIEndpoint overrides the Bot API URL format, e.g. for a local test server.
+	APIEndpoint string `json:"api_endpoint,omitempty"`
+}
+
+const placeholderBotToken = "PUT_YOUR_BOT_TOKEN_HERE"
+
+var (
+	notifiersMu sync.RWMutex
+	notifiers   []Notifier
+)
+
+func setNotifiers(ns []Notifier) {
+	notifiersMu.Lock()
+	defer notifiersMu.Unlock()
+	notifiers = ns
+}
+
+func activeNotifiers() []Notifier {
+	notifiersMu.RLock()
+	defer notifiersMu.RUnlock()
+	return notifiers
+}
+
+// findNotifier returns the active notifier called name, or nil.
+func findNotifier(name string) Notifier {
+	for _, n := range activeNotifiers() {
+		if n.Name() == name {
+			return n
+		}
+	}
+	return nil
+}
+
+// notifierConfigs returns cfg.Notifiers plus the top-level bot_token/chat_id
+// pair, which is what the GUI edits.
+func notifierConfigs(cfg Config) []NotifierConfig {
+	configs := append([]NotifierConfig{}, cfg.Notifiers...)
+	if cfg.BotToken != "" && cfg.BotToken != placeholderBotToken && cfg.ChatID != 0 {
+		configs = append(configs, NotifierConfig{
+			Type:     "telegram",
+			Name:     "telegram",
+			BotToken: cfg.BotToken,
+			ChatID:   cfg.ChatID,
+		})
+	}
+	return configs
+}
+
+// newNotifiers builds every configured notifier. Ones that fail to set up
+// are reported in errs and left out.
+func newNotifiers(cfg Config) (ns []Notifier, errs []error) {
+	for i, nc := range notifierConfigs(cfg) {
+		name := nc.Name
+		if name == "" {
+			name = fmt.Sprintf("%s-%d", nc.Type, i+1)
+		}
+
+		var n Notifier
+		var err error
+		switch nc.Type {
+		case "telegram":
+			n, err = NewTelegramNotifier(name, nc.BotToken, nc.ChatID, nc.APIEndpoint)
+		default:
+			err = fmt.Errorf("unknown notifier type %q", nc.Type)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		ns = append(ns, n)
+	}
+	return ns, errs
+}
+
+// fanOut runs send for every notifier concurrently and logs each result.
+func fanOut(notifiers []Notifier, logf func(format string, args ...any), what string, send func(Notifier) (Delivery, error)) []Delivery {
+	results := make([]Delivery, len(notifiers))
+	var wg sync.WaitGroup
+	for i, n := range notifiers {
+		wg.Add(1)
+		go func(i int, n Notifier) {
+			defer wg.Done()
+			d, err := send(n)
+			d.Notifier = n.Name()
+			d.Err = err
+			results[i] = d
+		}(i, n)
+	}
+	wg.Wait()
+
+	for _, d := range results {
+		if d.Err != nil {
+			logf("%s: failed to send %s: %v", d.Notifier, what, d.Err)
+		} else {
+			logf("%s: %s sent", d.Notifier, what)
+		}
+	}
+	return results
+}
+
+// UploadLimiter is implemented by notifiers that reject files over a size.
+type UploadLimiter interface {
+	MaxUploadBytes() int64
+}
+
+// uploadLimit is the smallest upload limit of ns, or 0 if none has one.
+func uploadLimit(ns []Notifier) int64 {
+	var limit int64
+	for _, n := range ns {
+		if l, ok := n.(UploadLimiter); ok && (limit == 0 || l.MaxUploadBytes() < limit) {
+			limit = l.MaxUploadBytes()
+		}
+	}
+	return limit
+}
+
+// deliverVideo sends a recording to every notifier. Videos over a
+// notifier's upload limit are re-encoded or split first (see fitForUpload),
+// and parts go out in order. Failed deliveries are queued in the outbox, and
+// the notifier also gets a text message pointing at the local file.
+func deliverVideo(path, caption string, logf func(format string, args ...any)) []Delivery {
+	defer holdFile(path)()
+	ns := activeNotifiers()
+
+	// Prepare everything before sending so re-encoding doesn't use up the
+	// upload timeouts. Notifiers with the same limit share the files.
+	type upload struct {
+		files []string
+		err   error
+	}
+	uploads := map[int64]upload{}
+	for _, n := range ns {
+		if l, ok := n.(UploadLimiter); ok {
+			limit := l.MaxUploadBytes()
+			if _, ok := uploads[limit]; !ok {
+				files, err := fitForUpload(path, limit, logf)
+				uploads[limit] = upload{files, err}
+			}
+		}
+	}
+
+	// failed queues entries for retry unless the file can never be sent,
+	// and tells the notifier where the video is.
+	failed := func(n Notifier, err error, retry []OutboxEntry) {
+		if !errors.Is(err, ErrTooLarge) {
+			for _, e := range retry {
+				if qErr := outbox.Add(e); qErr != nil {
+					logf("%s: cannot queue %s for retry: %v", n.Name(), e.Path, qErr)
+				}
+			}
+			if outbox != nil {
+				logf("%s: video queued for retry", n.Name())
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		defer cancel()
+		text := fmt.Sprintf("%s\nVideo recorded but failed to send: %v\n%s", caption, err, path)
+		if _, msgErr := n.SendText(ctx, text); msgErr != nil {
+			logf("%s: failed to send notification message: %v", n.Name(), msgErr)
+		}
+	}
+
+	results := fanOut(ns, logf, "video", func(n Notifier) (Delivery, error) {
+		files := []string{path}
+		if l, ok := n.(UploadLimiter); ok {
+			u := uploads[l.MaxUploadBytes()]
+			if u.err != nil {
+				err := fmt.Errorf("prepare upload: %w", u.err)
+				failed(n, err, []OutboxEntry{{Notifier: n.Name(), Kind: "video", Path: path, Text: caption, Sidecar: sidecarPath(path)}})
+				return Delivery{}, err
+			}
+			files = u.files
+		}
+
+		var d Delivery
+		for i, f := range files {
+			ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+			var err error
+			d, err = n.SendVideo(ctx, f, partCaption(caption, i, len(files)))
+			cancel()
+			if err == nil {
+				continue
+			}
+
+			var retry []OutboxEntry
+			for j, rest := range files[i:] {
+				retry = append(retry, OutboxEntry{Notifier: n.Name(), Kind: "video", Path: rest, Text: partCaption(caption, i+j, len(files)), Sidecar: sidecarPath(path)})
+			}
+			failed(n, err, retry)
+			return d, err
+		}
+		return d, nil
+	})
+
+	for _, u := range uploads {
+		removeUploadCopies(path, u.files)
+	}
+	return results
+}
+
+// deliverPhoto sends a photo to every notifier, queueing failed deliveries
+// for retry. sidecar, if set, notes a successful retry.
+func deliverPhoto(path, caption, sidecar string, logf func(format string, args ...any)) []Delivery {
+	defer holdFile(path)()
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	return fanOut(activeNotifiers(), logf, "photo", func(n Notifier) (Delivery, error) {
+		d, err := n.SendPhoto(ctx, path, caption)
+		if err != nil && !errors.Is(err, ErrTooLarge) {
+			entry := OutboxEntry{Notifier: n.Name(), Kind: "photo", Path: path, Text: caption, Sidecar: sidecar}
+			if qErr := outbox.Add(entry); qErr != nil {
+				logf("%s: cannot queue photo for retry: %v", n.Name(), qErr)
+			}
+		}
+		return d, err
+	})
+}
+
+// partCaption numbers the caption of part i (from 0) when a video was split.
+func partCaption(caption string, i, parts int) string {
+	if parts <= 1 {
+		return caption
+	}
+	return fmt.Sprintf("%s (part %d/%d)", caption, i+1, parts)
+}
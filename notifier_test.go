@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNotifier records what it was asked to send and fails while err is set.
+type fakeNotifier struct {
+	name  string
+	limit int64
+
+	mu     sync.Mutex
+	err    error
+	texts  []string
+	videos []string
+	photos []string
+}
+
+func (f *fakeNotifier) Name() string { return f.name }
+
+func (f *fakeNotifier) MaxUploadBytes() int64 { return f.limit }
+
+func (f *fakeNotifier) send(list *[]string, item string) (Delivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return Delivery{}, f.err
+	}
+	*list = append(*list, item)
+	return Delivery{MessageID: strconv.Itoa(len(*list)), Sent: time.Now()}, nil
+}
+
+func (f *fakeNotifier) SendText(ctx context.Context, text string) (Delivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Text always gets through, like a short message on a flaky link.
+	f.texts = append(f.texts, text)
+	return Delivery{MessageID: "text", Sent: time.Now()}, nil
+}
+
+func (f *fakeNotifier) SendVideo(ctx context.Context, path, caption string) (Delivery, error) {
+	return f.send(&f.videos, path)
+}
+
+func (f *fakeNotifier) SendPhoto(ctx context.Context, path, caption string) (Delivery, error) {
+	return f.send(&f.photos, path)
+}
+
+// useNotifiers makes ns the active notifiers and gives the test its own
+// outbox.
+func useNotifiers(t *testing.T, ns ...Notifier) {
+	t.Helper()
+	o, err := OpenOutbox(filepath.Join(t.TempDir(), "outbox.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldOutbox, oldNotifiers := outbox, activeNotifiers()
+	outbox = o
+	setNotifiers(ns)
+	t.Cleanup(func() {
+		outbox = oldOutbox
+		setNotifiers(oldNotifiers)
+	})
+}
+
+func TestDeliverVideoPrepareFails(t *testing.T) {
+	n := &fakeNotifier{name: "limited", limit: 1024}
+	useNotifiers(t, n)
+	missing := filepath.Join(t.TempDir(), "capture.mp4")
+
+	ds := deliverVideo(missing, "Laptop lid opened", t.Logf)
+	if len(ds) != 1 || ds[0].Err == nil {
+		t.Fatalf("deliveries = %+v, want one failure", ds)
+	}
+	if len(n.texts) != 1 {
+		t.Errorf("sent %d text fallbacks, want 1", len(n.texts))
+	}
+	paths := outbox.Paths()
+	if len(paths) != 1 || paths[0] != missing {
+		t.Errorf("queued %v, want the original %s", paths, missing)
+	}
+}
+
+func TestDeliverVideoQueuesFailures(t *testing.T) {
+	video := filepath.Join(t.TempDir(), "capture.mp4")
+	if err := os.WriteFile(video, []byte("video"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ok := &fakeNotifier{name: "ok", limit: 1024}
+	down := &fakeNotifier{name: "down", limit: 1024, err: errors.New("network is unreachable")}
+	useNotifiers(t, ok, down)
+
+	ds := deliverVideo(video, "Laptop lid opened", t.Logf)
+	if len(ds) != 2 || ds[0].Err != nil || ds[1].Err == nil {
+		t.Fatalf("deliveries = %+v, want ok then down failing", ds)
+	}
+	if len(ok.videos) != 1 || ok.videos[0] != video {
+		t.Errorf("ok got %v, want %s", ok.videos, video)
+	}
+	if len(down.texts) != 1 {
+		t.Errorf("down got %d text fallbacks, want 1", len(down.texts))
+	}
+	if n := outbox.Len(); n != 1 {
+		t.Errorf("outbox has %d entries, want 1", n)
+	}
+	if _, err := os.Stat(video); err != nil {
+		t.Errorf("original removed: %v", err)
+	}
+}
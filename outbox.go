@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OutboxEntry is one delivery still waiting to go out.
+type OutboxEntry struct {
+	ID        string    `json:"id"`
+	Notifier  string    `json:"notifier"`
+	Kind      string    `json:"kind"` // "video", "photo" or "text"
+	Path      string    `json:"path,omitempty"`
+	Text      string    `json:"text,omitempty"` // caption, or the message for "text"
+	Created   time.Time `json:"created"`
+	Attempts  int       `json:"attempts"`
+	NextTry   time.Time `json:"next_try"`
+	LastError string    `json:"last_error,omitempty"`
+	// Sidecar, if set, is the recording's sidecar, which notes the delivery
+	// once a retry succeeds.
+	Sidecar string `json:"sidecar,omitempty"`
+}
+
+// Outbox is an on-disk queue of failed deliveries. Entries are retried with
+// exponential backoff until they are sent or older than MaxAge, and survive
+// restarts because every change is written back to the file.
+type Outbox struct {
+	MaxAge    time.Duration
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// OnChange is called with the queue depth after entries are added or removed.
+	OnChange func(pending int)
+	Logf     func(format string, args ...any)
+
+	path string
+	wake chan struct{}
+
+	// passMu keeps Run and Drain from sending the same entry twice.
+	passMu sync.Mutex
+
+	mu      sync.Mutex
+	entries []OutboxEntry
+	seq     int
+}
+
+var outbox *Outbox
+
+func outboxPath() string {
+	return filepath.Join(configDir(), "outbox.json")
+}
+
+// OpenOutbox loads the queue stored at path, if any.
+func OpenOutbox(path string) (*Outbox, error) {
+	o := &Outbox{
+		MaxAge:    24 * time.Hour,
+		BaseDelay: 30 * time.Second,
+		MaxDelay:  30 * time.Minute,
+		path:      path,
+		wake:      make(chan struct{}, 1),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &o.entries); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return o, nil
+}
+
+// Paths lists the files of all pending uploads.
+func (o *Outbox) Paths() []string {
+	if o == nil {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var paths []string
+	for _, e := range o.entries {
+		if e.Path != "" {
+			paths = append(paths, e.Path)
+		}
+	}
+	return paths
+}
+
+// Drain retries every entry now, ignoring backoff, until the queue is empty,
+// a pass makes no progress or ctx is done. It returns how many are left;
+// they stay queued for the next start.
+func (o *Outbox) Drain(ctx context.Context, lookup func(name string) Notifier) int {
+	if o == nil {
+		return 0
+	}
+	for o.Len() > 0 {
+		before := o.Len()
+		o.mu.Lock()
+		for i := range o.entries {
+			o.entries[i].NextTry = time.Time{}
+		}
+		o.mu.Unlock()
+
+		done := make(chan struct{})
+		go func() {
+			o.retryDue(lookup)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return o.Len()
+		}
+		if o.Len() >= before {
+			break
+		}
+	}
+	return o.Len()
+}
+
+// Len is the number of pending deliveries. A nil outbox is always empty.
+func (o *Outbox) Len() int {
+	if o == nil {
+		return 0
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries)
+}
+
+// Add queues e for its first retry after BaseDelay.
+func (o *Outbox) Add(e OutboxEntry) error {
+	if o == nil {
+		return fmt.Errorf("no outbox")
+	}
+	o.mu.Lock()
+	now := time.Now()
+	o.seq++
+	e.ID = fmt.Sprintf("%d-%d", now.UnixNano(), o.seq)
+	e.Created = now
+	e.NextTry = now.Add(o.BaseDelay)
+	o.entries = append(o.entries, e)
+	err := o.saveLocked()
+	pending := len(o.entries)
+	o.mu.Unlock()
+
+	o.changed(pending)
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+// Run retries due entries until stop is closed. lookup resolves an entry's
+// notifier by name; entries whose notifier isn't available wait.
+func (o *Outbox) Run(stop <-chan struct{}, lookup func(name string) Notifier) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-o.wake:
+		case <-timer.C:
+		}
+
+		next := o.retryDue(lookup)
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(time.Until(next))
+	}
+}
+
+// retryDue attempts every due entry once and returns when to look again.
+func (o *Outbox) retryDue(lookup func(name string) Notifier) time.Time {
+	o.passMu.Lock()
+	defer o.passMu.Unlock()
+	now := time.Now()
+
+	o.mu.Lock()
+	var due []OutboxEntry
+	var kept []OutboxEntry
+	for _, e := range o.entries {
+		switch {
+		case now.Sub(e.Created) > o.MaxAge:
+			o.logf("Outbox: giving up on %s %s to %s after %d attempts: %s", e.Kind, e.Path, e.Notifier, e.Attempts, e.LastError)
+		case !e.NextTry.After(now) && lookup(e.Notifier) != nil:
+			due = append(due, e)
+		default:
+			kept = append(kept, e)
+		}
+	}
+	expired := len(o.entries) - len(due) - len(kept)
+	o.mu.Unlock()
+
+	var retry, delivered []OutboxEntry
+	for _, e := range due {
+		d, err := o.deliver(lookup(e.Notifier), e)
+		if err == nil {
+			o.logf("Outbox: %s %s delivered to %s", e.Kind, e.Path, e.Notifier)
+			delivered = append(delivered, e)
+			if e.Sidecar != "" {
+				d.Notifier = e.Notifier
+				if err := noteInSidecar(e.Sidecar, e.Kind, []Delivery{d}); err != nil {
+					o.logf("Outbox: sidecar: %v", err)
+				}
+			}
+			continue
+		}
+		e.Attempts++
+		e.LastError = err.Error()
+		e.NextTry = time.Now().Add(o.backoff(e.Attempts))
+		o.logf("Outbox: retry %d of %s to %s failed: %v", e.Attempts, e.Kind, e.Notifier, err)
+		retry = append(retry, e)
+	}
+
+	o.mu.Lock()
+	// Keep entries added while we were sending.
+	handled := make(map[string]bool, len(due)+len(kept))
+	for _, e := range due {
+		handled[e.ID] = true
+	}
+	for _, e := range kept {
+		handled[e.ID] = true
+	}
+	var added []OutboxEntry
+	for _, e := range o.entries {
+		if !handled[e.ID] && now.Sub(e.Created) <= o.MaxAge {
+			added = append(added, e)
+		}
+	}
+	o.entries = append(append(kept, retry...), added...)
+	if len(delivered) > 0 || expired > 0 || len(retry) > 0 {
+		if err := o.saveLocked(); err != nil {
+			o.logf("Outbox: %v", err)
+		}
+	}
+	pending := len(o.entries)
+	next := now.Add(o.MaxDelay)
+	stillQueued := map[string]bool{}
+	for _, e := range o.entries {
+		if e.NextTry.Before(next) {
+			next = e.NextTry
+		}
+		stillQueued[e.Path] = true
+	}
+	o.mu.Unlock()
+
+	for _, e := range delivered {
+		// Re-encoded and split copies were only kept for this retry; sealed
+		// ones stay with the recording, see removeUploadCopies.
+		if uploadCopy.MatchString(e.Path) && !strings.HasSuffix(e.Path, sealExt) && !stillQueued[e.Path] {
+			os.Remove(e.Path)
+		}
+	}
+	if len(delivered) > 0 || expired > 0 {
+		o.changed(pending)
+	}
+	return next
+}
+
+func (o *Outbox) deliver(n Notifier, e OutboxEntry) (Delivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	if e.Kind != "text" {
+		defer holdFile(e.Path)()
+		if _, err := os.Stat(e.Path); err != nil {
+			return Delivery{}, err
+		}
+	}
+
+	switch e.Kind {
+	case "video":
+		return n.SendVideo(ctx, e.Path, e.Text)
+	case "photo":
+		return n.SendPhoto(ctx, e.Path, e.Text)
+	case "text":
+		return n.SendText(ctx, e.Text)
+	}
+	return Delivery{}, fmt.Errorf("unknown delivery kind %q", e.Kind)
+}
+
+func (o *Outbox) backoff(attempts int) time.Duration {
+	d := o.BaseDelay
+	for i := 1; i < attempts && d < o.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > o.MaxDelay {
+		d = o.MaxDelay
+	}
+	return d
+}
+
+// saveLocked rewrites the queue file via a rename so a crash never leaves
+// it half written.
+func (o *Outbox) saveLocked() error {
+	data, err := json.MarshalIndent(o.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := o.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("save outbox: %w", err)
+	}
+	if err := os.Rename(tmp, o.path); err != nil {
+		return fmt.Errorf("save outbox: %w", err)
+	}
+	return nil
+}
+
+// withQueue adds the upload queue depth to a status line when something is
+// waiting to be delivered.
+func withQueue(status string) string {
+	if n := outbox.Len(); n > 0 {
+		return fmt.Sprintf("%s (%d upload(s) queued)", status, n)
+	}
+	return status
+}
+
+func (o *Outbox) changed(pending int) {
+	if o.OnChange != nil {
+		o.OnChange(pending)
+	}
+}
+
+func (o *Outbox) logf(format string, args ...any) {
+	if o.Logf != nil {
+		o.Logf(format, args...)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutboxRetryNotesSidecar(t *testing.T) {
+	isolateHome(t)
+	dir := t.TempDir()
+	video := filepath.Join(dir, "capture_1.mp4")
+	photo := filepath.Join(dir, "capture_1.jpg")
+	for _, f := range []string{video, photo} {
+		if err := os.WriteFile(f, []byte("data"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sidecar := sidecarPath(video)
+	if err := saveSidecar(sidecar, Sidecar{Video: video, Deliveries: []SidecarDelivery{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	o, err := OpenOutbox(filepath.Join(dir, "outbox.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.BaseDelay = 0
+	n := &fakeNotifier{name: "tg", err: errors.New("offline")}
+	lookup := func(string) Notifier { return n }
+	o.Add(OutboxEntry{Notifier: "tg", Kind: "photo", Path: photo, Sidecar: sidecar})
+
+	o.retryDue(lookup) // still offline
+	n.mu.Lock()
+	n.err = nil
+	n.mu.Unlock()
+	o.Drain(context.Background(), lookup)
+	if o.Len() != 0 {
+		t.Fatalf("%d entries left after a successful retry", o.Len())
+	}
+
+	data, err := os.ReadFile(sidecar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sc Sidecar
+	if err := json.Unmarshal(data, &sc); err != nil {
+		t.Fatal(err)
+	}
+	if len(sc.Deliveries) != 1 {
+		t.Fatalf("sidecar deliveries = %+v, want the retried photo", sc.Deliveries)
+	}
+	d := sc.Deliveries[0]
+	if d.Notifier != "tg" || d.Kind != "photo" || d.MessageID != "1" || d.Error != "" || d.Sent.IsZero() {
+		t.Errorf("sidecar delivery = %+v", d)
+	}
+}
+
+func TestOutboxRemovesOnlyPlainUploadCopies(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "capture_1_part1.mp4")
+	sealed := filepath.Join(dir, "capture_2_part1.mp4"+sealExt)
+	for _, f := range []string{plain, sealed} {
+		if err := os.WriteFile(f, []byte("part"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	o, err := OpenOutbox(filepath.Join(dir, "outbox.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &fakeNotifier{name: "tg"}
+	for _, f := range []string{plain, sealed} {
+		o.Add(OutboxEntry{Notifier: "tg", Kind: "video", Path: f})
+	}
+	o.Drain(context.Background(), func(string) Notifier { return n })
+
+	if len(n.videos) != 2 {
+		t.Fatalf("sent %q, want both parts", n.videos)
+	}
+	if _, err := os.Stat(plain); !os.IsNotExist(err) {
+		t.Errorf("plaintext upload copy kept after delivery: %v", err)
+	}
+	if _, err := os.Stat(sealed); err != nil {
+		t.Errorf("sealed upload copy removed: %v", err)
+	}
+}
+
+// testOutbox opens an outbox in a temporary directory.
+func testOutbox(t *testing.T) *Outbox {
+	t.Helper()
+	o, err := OpenOutbox(filepath.Join(t.TempDir(), "outbox.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Logf = t.Logf
+	return o
+}
+
+// makeDue lets every entry be retried now.
+func makeDue(o *Outbox) {
+	o.mu.Lock()
+	for i := range o.entries {
+		o.entries[i].NextTry = time.Time{}
+	}
+	o.mu.Unlock()
+}
+
+func entries(o *Outbox) []OutboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]OutboxEntry(nil), o.entries...)
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	o := &Outbox{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	for attempts, want := range []time.Duration{1, 1, 2, 4, 8, 10, 10} {
+		if got := o.backoff(attempts); got != want*time.Second {
+			t.Errorf("backoff(%d) = %v, want %v", attempts, got, want*time.Second)
+		}
+	}
+	if got := o.backoff(1000); got != o.MaxDelay {
+		t.Errorf("backoff(1000) = %v, want MaxDelay", got)
+	}
+}
+
+func TestOutboxRetryBacksOff(t *testing.T) {
+	o := testOutbox(t)
+	o.BaseDelay, o.MaxDelay = time.Minute, 8*time.Minute
+	n := &fakeNotifier{name: "tg", err: errors.New("offline")}
+	lookup := func(string) Notifier { return n }
+	video := filepath.Join(t.TempDir(), "capture_1.mp4")
+	if err := os.WriteFile(video, []byte("video"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	o.Add(OutboxEntry{Notifier: "tg", Kind: "video", Path: video})
+
+	if e := entries(o)[0]; e.Attempts != 0 || time.Until(e.NextTry) < 59*time.Second {
+		t.Fatalf("new entry due %v from now, want BaseDelay", time.Until(e.NextTry))
+	}
+	// Not due yet: nothing is tried.
+	o.retryDue(lookup)
+	if e := entries(o)[0]; e.Attempts != 0 {
+		t.Fatalf("entry tried before it was due: %+v", e)
+	}
+
+	for i, want := range []time.Duration{1, 2, 4, 8, 8} {
+		makeDue(o)
+		before := time.Now()
+		next := o.retryDue(lookup)
+		e := entries(o)[0]
+		if e.Attempts != i+1 || e.LastError != "offline" {
+			t.Fatalf("after retry %d: %+v", i+1, e)
+		}
+		if wait := e.NextTry.Sub(before); wait < want*time.Minute || wait > want*time.Minute+time.Second {
+			t.Errorf("after retry %d the next is %v away, want %v", i+1, wait, want*time.Minute)
+		}
+		if next.After(e.NextTry) {
+			t.Errorf("after retry %d Run would wake at %v, after the entry's %v", i+1, next, e.NextTry)
+		}
+	}
+}
+
+func TestOutboxSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	video := filepath.Join(t.TempDir(), "capture_1.mp4")
+	if err := os.WriteFile(video, []byte("video"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	o, err := OpenOutbox(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &fakeNotifier{name: "tg", err: errors.New("offline")}
+	lookup := func(string) Notifier { return n }
+	o.Add(OutboxEntry{Notifier: "tg", Kind: "video", Path: video, Text: "Clip"})
+	o.Add(OutboxEntry{Notifier: "mail", Kind: "photo", Path: video, Text: "Lid opened"})
+	makeDue(o)
+	o.retryDue(lookup)
+	before := entries(o)
+
+	// The process restarts.
+	reopened, err := OpenOutbox(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := entries(reopened)
+	if len(after) != 2 {
+		t.Fatalf("%d entries after a restart, want 2", len(after))
+	}
+	for i, e := range after {
+		want := before[i]
+		if !e.Created.Equal(want.Created) || !e.NextTry.Equal(want.NextTry) {
+			t.Errorf("entry %d times: reloaded %v, %v; want %v, %v", i, e.Created, e.NextTry, want.Created, want.NextTry)
+		}
+		e.Created, e.NextTry, want.Created, want.NextTry = time.Time{}, time.Time{}, time.Time{}, time.Time{}
+		if e != want {
+			t.Errorf("entry %d: reloaded %+v, want %+v", i, e, want)
+		}
+	}
+	if after[0].Attempts != 1 || after[0].LastError != "offline" {
+		t.Errorf("retry state lost: %+v", after[0])
+	}
+
+	n.mu.Lock()
+	n.err = nil
+	n.mu.Unlock()
+	if left := reopened.Drain(context.Background(), lookup); left != 0 {
+		t.Fatalf("%d left after draining", left)
+	}
+	if len(n.videos) != 1 || len(n.photos) != 1 {
+		t.Errorf("delivered videos %q and photos %q", n.videos, n.photos)
+	}
+	if again, err := OpenOutbox(path); err != nil || again.Len() != 0 {
+		t.Errorf("after delivery the file still holds %d entries (%v)", again.Len(), err)
+	}
+}
+
+func TestOpenOutboxCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	if err := os.WriteFile(path, []byte("[{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenOutbox(path); err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("OpenOutbox() = %v, want an error naming the file", err)
+	}
+}
+
+func TestOutboxExpires(t *testing.T) {
+	o := testOutbox(t)
+	o.MaxAge = time.Hour
+	var pending []int
+	o.OnChange = func(n int) { pending = append(pending, n) }
+	n := &fakeNotifier{name: "tg", err: errors.New("offline")}
+	lookup := func(string) Notifier { return n }
+
+	o.Add(OutboxEntry{Notifier: "tg", Kind: "video", Path: "old.mp4"})
+	o.Add(OutboxEntry{Notifier: "tg", Kind: "video", Path: "new.mp4"})
+	o.mu.Lock()
+	o.entries[0].Created = time.Now().Add(-2 * time.Hour)
+	o.mu.Unlock()
+	makeDue(o)
+	pending = nil
+
+	o.retryDue(lookup)
+	left := entries(o)
+	if len(left) != 1 || left[0].Path != "new.mp4" || left[0].Attempts != 1 {
+		t.Fatalf("entries after expiry: %+v", left)
+	}
+	if !reflect.DeepEqual(pending, []int{1}) {
+		t.Errorf("OnChange got %v, want [1]", pending)
+	}
+	if reopened, err := OpenOutbox(o.path); err != nil || reopened.Len() != 1 {
+		t.Errorf("expired entry still on disk: %v entries, %v", reopened.Len(), err)
+	}
+
+	// Expired entries aren't sent even if the notifier is back.
+	n.mu.Lock()
+	n.err = nil
+	n.mu.Unlock()
+	o.mu.Lock()
+	o.entries[0].Created = time.Now().Add(-2 * time.Hour)
+	o.mu.Unlock()
+	if left := o.Drain(context.Background(), lookup); left != 0 {
+		t.Errorf("%d left", left)
+	}
+	if len(n.videos) != 0 {
+		t.Errorf("sent expired entries %q", n.videos)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+const defaultOverlayScale = 0.6
+
+// Overlay stamps the time, host, trigger and frame number onto frames
+// before they are written, so a clip carries its own metadata.
+type Overlay struct {
+	// Position is "top-left" (default), "top-right", "bottom-left" or
+	// "bottom-right".
+	Position string
+	Scale    float64
+	Host     string
+	Trigger  string
+}
+
+// overlayFromConfig returns nil unless cfg enables the overlay.
+func overlayFromConfig(cfg Config, trigger string) *Overlay {
+	if !cfg.Overlay {
+		return nil
+	}
+	return &Overlay{
+		Position: cfg.OverlayPosition,
+		Scale:    cfg.OverlayScale,
+		Host:     hostName(),
+		Trigger:  trigger,
+	}
+}
+
+// Draw stamps frame n, captured at t, onto img.
+func (o *Overlay) Draw(img *gocv.Mat, n int, t time.Time) {
+	scale := o.Scale
+	if scale <= 0 {
+		scale = defaultOverlayScale
+	}
+	text := fmt.Sprintf("%s  %s  %s  #%d", t.Format("2006-01-02 15:04:05.000"), o.Host, o.Trigger, n)
+
+	thickness := max(1, int(scale*2))
+	size := gocv.GetTextSize(text, gocv.FontHersheySimplex, scale, thickness)
+	margin := max(4, int(10*scale))
+
+	x, y := margin, margin+size.Y
+	switch o.Position {
+	case "top-right":
+		x = img.Cols() - size.X - margin
+	case "bottom-left":
+		y = img.Rows() - margin
+	case "bottom-right":
+		x, y = img.Cols()-size.X-margin, img.Rows()-margin
+	}
+	// On a frame too small for the text, cut off its end rather than the
+	// time at its start.
+	x = max(x, margin)
+	y = max(y, margin+size.Y)
+
+	// A dark outline keeps the text readable on bright scenes.
+	pt := image.Pt(x, y)
+	gocv.PutText(img, text, pt, gocv.FontHersheySimplex, scale, color.RGBA{0, 0, 0, 0}, thickness+2)
+	gocv.PutText(img, text, pt, gocv.FontHersheySimplex, scale, color.RGBA{255, 255, 255, 0}, thickness)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// drawOverlay draws o on a grey w x h frame and returns the bounds of the
+// pixels it changed.
+func drawOverlay(t *testing.T, o *Overlay, w, h int) image.Rectangle {
+	t.Helper()
+	orig := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(128, 128, 128, 0), h, w, gocv.MatTypeCV8UC3)
+	defer orig.Close()
+	img := orig.Clone()
+	defer img.Close()
+
+	o.Draw(&img, 12, time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC))
+	if img.Cols() != w || img.Rows() != h || img.Type() != orig.Type() {
+		t.Fatalf("frame is %dx%d %v after Draw, want %dx%d %v", img.Cols(), img.Rows(), img.Type(), w, h, orig.Type())
+	}
+
+	diff, gray := gocv.NewMat(), gocv.NewMat()
+	defer diff.Close()
+	defer gray.Close()
+	gocv.AbsDiff(img, orig, &diff)
+	gocv.CvtColor(diff, &gray, gocv.ColorBGRToGray)
+	var drawn image.Rectangle
+	for i, v := range gray.ToBytes() {
+		if v != 0 {
+			x, y := i%w, i/w
+			drawn = drawn.Union(image.Rect(x, y, x+1, y+1))
+		}
+	}
+	if drawn.Empty() {
+		t.Fatal("nothing drawn")
+	}
+	return drawn
+}
+
+func TestOverlayDraw(t *testing.T) {
+	const w, h = 1280, 720
+	tests := []struct {
+		position    string
+		right, down bool
+	}{
+		{"", false, false},
+		{"top-left", false, false},
+		{"top-right", true, false},
+		{"bottom-left", false, true},
+		{"bottom-right", true, true},
+		{"middle", false, false},
+	}
+	for _, tt := range tests {
+		drawn := drawOverlay(t, &Overlay{Position: tt.position, Host: "host", Trigger: "lid"}, w, h)
+		// Inside the frame, not clipped by an edge.
+		if !drawn.In(image.Rect(1, 1, w-1, h-1)) {
+			t.Errorf("%q: text at %v runs off the %dx%d frame", tt.position, drawn, w, h)
+		}
+		if right := drawn.Min.X > w/2; right != tt.right {
+			t.Errorf("%q: text at %v, want on the right %v", tt.position, drawn, tt.right)
+		}
+		if down := drawn.Min.Y > h/2; down != tt.down {
+			t.Errorf("%q: text at %v, want at the bottom %v", tt.position, drawn, tt.down)
+		}
+	}
+}
+
+func TestOverlayDrawScale(t *testing.T) {
+	small := drawOverlay(t, &Overlay{Scale: 0.4, Host: "host", Trigger: "lid"}, 1280, 720)
+	large := drawOverlay(t, &Overlay{Scale: 1, Host: "host", Trigger: "lid"}, 1280, 720)
+	if large.Dx() <= small.Dx() || large.Dy() <= small.Dy() {
+		t.Errorf("text at scale 1 is %v, not larger than %v at 0.4", large.Size(), small.Size())
+	}
+}
+
+func TestOverlayDrawSmallFrame(t *testing.T) {
+	// The text is wider than the frame: it starts at the left edge
+	// wherever it was asked to go, so the time stays readable.
+	for _, position := range []string{"top-right", "bottom-right"} {
+		drawn := drawOverlay(t, &Overlay{Position: position, Host: "host", Trigger: "lid"}, 160, 40)
+		if drawn.Min.X <= 0 || drawn.Min.X > 10 || drawn.Min.Y <= 0 {
+			t.Errorf("%q: text at %v, want it to start inside the top left", position, drawn)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+const defaultPreRollMaxMB = 200
+
+// PreRoll keeps the camera open while the monitor is armed and holds the
+// last Seconds of frames in memory, so a triggered recording can start with
+// the moments before the lid opened instead of the camera warm-up. It also
+// holds the camera while the live preview has viewers, offering every frame
+// to Live; with Seconds 0 that is all it does.
+//
+// A nil *PreRoll is valid and does nothing.
+type PreRoll struct {
+	Device   Device
+	Seconds  int
+	MaxBytes int64
+	Live     *LiveView
+
+	mu      sync.Mutex
+	want    bool // Start was called and not undone by Stop or Detach
+	viewers int  // live preview streams
+	busy    bool // a recorder owns the camera
+	src     FrameSource
+	w, h    int
+	fps     int
+	ring    []gocv.Mat
+	times   []time.Time // when each ring frame was captured
+	head    int         // oldest frame once the ring is full
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// newPreRoll buffers nothing unless cfg enables pre-roll.
+func newPreRoll(d Device, cfg Config) *PreRoll {
+	p := &PreRoll{Device: d}
+	if cfg.PreRollSeconds <= 0 {
+		return p
+	}
+	maxMB := cfg.PreRollMaxMB
+	if maxMB <= 0 {
+		maxMB = defaultPreRollMaxMB
+	}
+	p.Seconds = cfg.PreRollSeconds
+	p.MaxBytes = int64(maxMB) * 1024 * 1024
+	return p
+}
+
+// Start opens the camera and begins buffering. If a recorder still owns the
+// camera, buffering starts once it is released.
+func (p *PreRoll) Start() error {
+	if p == nil || p.Seconds <= 0 {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	wanted := p.want
+	p.want = true
+	switch {
+	case p.busy:
+		return nil
+	case p.src == nil:
+		return p.startLocked()
+	case !wanted:
+		// Open for the preview only; keep the source and start buffering.
+		p.restartLocked()
+	}
+	return nil
+}
+
+func (p *PreRoll) startLocked() error {
+	src, w, h, fps, err := openSource(p.Device)
+	if err != nil {
+		p.want = false
+		return err
+	}
+	p.src, p.w, p.h, p.fps = src, w, h, fps
+	p.runLocked()
+	return nil
+}
+
+func (p *PreRoll) runLocked() {
+	keep := 0
+	if p.want {
+		keep = p.Seconds * p.fps
+	}
+	p.stop = make(chan struct{})
+	p.stopped = make(chan struct{})
+	go p.buffer(p.src, p.fps, keep, p.stop, p.stopped)
+}
+
+// restartLocked drops the buffered frames and reads on from the same
+// source, buffering only if wanted.
+func (p *PreRoll) restartLocked() {
+	src, w, h, fps, frames, _ := p.detachLocked()
+	closeMats(frames)
+	p.src, p.w, p.h, p.fps = src, w, h, fps
+	p.runLocked()
+}
+
+// Stop drops buffered frames and closes the camera unless the live preview
+// is using it.
+func (p *PreRoll) Stop() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.want = false
+	if p.viewers > 0 && p.src != nil {
+		p.restartLocked()
+		return
+	}
+	p.closeLocked()
+}
+
+func (p *PreRoll) closeLocked() {
+	src, _, _, _, frames, _ := p.detachLocked()
+	if src != nil {
+		src.Close()
+	}
+	closeMats(frames)
+}
+
+// Buffering reports whether Start is in effect.
+func (p *PreRoll) Buffering() bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.want
+}
+
+// Watch opens the camera for a live preview viewer, unless it is already
+// open or a recorder has it; Unwatch undoes it.
+func (p *PreRoll) Watch() error {
+	if p == nil {
+		return errors.New("no camera")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.viewers++
+	if p.busy || p.src != nil {
+		return nil
+	}
+	if err := p.startLocked(); err != nil {
+		p.viewers--
+		return err
+	}
+	return nil
+}
+
+func (p *PreRoll) Unwatch() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.viewers--
+	if p.viewers == 0 && !p.want {
+		p.closeLocked()
+	}
+}
+
+// Detach stops buffering and hands the open source, its negotiated size and
+// rate, and the buffered frames (oldest first) with their capture times to
+// the caller, who must close them and call Release when done with the
+// camera. It returns a nil source if the camera wasn't open.
+func (p *PreRoll) Detach() (src FrameSource, w, h, fps int, frames []gocv.Mat, times []time.Time) {
+	if p == nil {
+		return nil, 0, 0, 0, nil, nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.want = false
+	p.busy = true
+	return p.detachLocked()
+}
+
+// Release marks the camera as free again and reopens it if Start was called
+// in the meantime or the preview is being watched.
+func (p *PreRoll) Release() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.busy = false
+	if (p.want || p.viewers > 0) && p.src == nil {
+		if err := p.startLocked(); err != nil {
+			log.Printf("Pre-roll: %v", err)
+		}
+	}
+}
+
+func (p *PreRoll) detachLocked() (FrameSource, int, int, int, []gocv.Mat, []time.Time) {
+	if p.src == nil {
+		return nil, 0, 0, 0, nil, nil
+	}
+	close(p.stop)
+	<-p.stopped
+
+	frames := make([]gocv.Mat, 0, len(p.ring))
+	frames = append(frames, p.ring[p.head:]...)
+	frames = append(frames, p.ring[:p.head]...)
+	times := make([]time.Time, 0, len(p.times))
+	times = append(times, p.times[p.head:]...)
+	times = append(times, p.times[:p.head]...)
+	src := p.src
+	p.src, p.ring, p.times, p.head = nil, nil, nil, 0
+	return src, p.w, p.h, p.fps, frames, times
+}
+
+// buffer reads frames at fps into the ring until stop is closed. The ring
+// grows up to maxFrames or MaxBytes, whichever is hit first, and then
+// overwrites its oldest frame.
+func (p *PreRoll) buffer(src FrameSource, fps, maxFrames int, stop, stopped chan struct{}) {
+	defer close(stopped)
+
+	var size int64
+
+	img := gocv.NewMat()
+	defer img.Close()
+
+	tick := time.NewTicker(time.Second / time.Duration(fps))
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+		}
+
+		if ok := src.Read(&img); !ok || img.Empty() {
+			continue
+		}
+		at := time.Now()
+		p.Live.Offer(img)
+		frameBytes := int64(img.Total() * img.ElemSize())
+
+		if len(p.ring) < maxFrames && (p.MaxBytes <= 0 || size+frameBytes <= p.MaxBytes) {
+			p.ring = append(p.ring, img.Clone())
+			p.times = append(p.times, at)
+			size += frameBytes
+			continue
+		}
+		if len(p.ring) == 0 {
+			continue // a single frame is over the cap
+		}
+		img.CopyTo(&p.ring[p.head])
+		p.times[p.head] = at
+		p.head = (p.head + 1) % len(p.ring)
+	}
+}
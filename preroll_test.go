@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPreRollCaptureTimes(t *testing.T) {
+	p := &PreRoll{Device: Device{Id: -1, Source: "test:pattern?size=64x48&fps=20"}, Seconds: 1}
+	before := time.Now()
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// Run long enough for the ring to wrap.
+	time.Sleep(1500 * time.Millisecond)
+	src, _, _, fps, frames, times := p.Detach()
+	after := time.Now()
+	defer p.Release()
+	defer closeMats(frames)
+	if src == nil {
+		t.Fatal("Detach() returned no source")
+	}
+	defer src.Close()
+
+	if len(frames) != fps || len(times) != len(frames) {
+		t.Fatalf("got %d frames and %d times, want %d of each", len(frames), len(times), fps)
+	}
+	for i, at := range times {
+		if at.Before(before) || at.After(after) {
+			t.Errorf("frame %d captured at %v, outside the run", i, at)
+		}
+		if i > 0 && !at.After(times[i-1]) {
+			t.Errorf("frame %d at %v is not after frame %d at %v", i, at, i-1, times[i-1])
+		}
+	}
+	// The ring holds the last second, so the oldest frame is from after
+	// the first half second.
+	if oldest := times[0].Sub(before); oldest < 400*time.Millisecond {
+		t.Errorf("oldest frame is %v into the run, want the ring to have wrapped", oldest)
+	}
+}
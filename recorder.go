@@ -0,0 +1,351 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"gocv.io/x/gocv"
+)
+
+// Recording is the outcome of a single Recorder run.
+type Recording struct {
+	Path    string
+	Device  Device
+	Trigger string
+	// Format is the codec and container actually used.
+	Format  VideoFormat
+	Frames  int
+	Dropped int
+	// PreRollFrames of Frames were captured before the trigger.
+	PreRollFrames int
+	// Snapshot is the JPEG saved next to Path, if any.
+	Snapshot string
+	Faces    FaceResult
+	// StopReason is "duration", with a motion gate "idle" or "max", or
+	// "stopped" if Recorder.Stop ended it early.
+	StopReason string
+	// FPS is the frame rate actually achieved; SourceFPS and the size are
+	// what the camera negotiated.
+	FPS           float64
+	SourceFPS     int
+	Width, Height int
+	Start, End    time.Time
+	Err           error
+
+	// release ends the hold on the recording's file that keeps retention
+	// away while it is written; see Recording.Release.
+	release func()
+}
+
+// Release lets retention delete the recording again. Recorder.Record keeps
+// it held so that it isn't swept between being written and being
+// delivered; it is a no-op for a failed recording.
+func (rec Recording) Release() {
+	if rec.release != nil {
+		rec.release()
+	}
+}
+
+// Recorder captures Duration of video from Device into a file in Dir named
+// after Template.
+type Recorder struct {
+	Device   Device
+	Duration time.Duration
+	Dir      string // defaults to videosDir()
+	Template string // see expandTemplate; defaults to capture_{time:20060102_150405}
+	Trigger  string // for the {trigger} placeholder
+	// Formats are tried in order until a writer opens; defaults to
+	// defaultVideoFormats.
+	Formats []VideoFormat
+	// PreRoll, if set, hands over its open camera and buffered frames.
+	PreRoll *PreRoll
+	// Motion, if set, makes Duration a minimum: recording goes on while
+	// there is motion, up to Motion.Max.
+	Motion *MotionGate
+
+	// OnSnapshot, if set, gets a JPEG of the sharpest of the first
+	// SnapshotFrames live frames as soon as it is saved, while recording
+	// continues. It runs on its own goroutine.
+	OnSnapshot     func(path string)
+	SnapshotFrames int
+
+	// FaceModel, if set, is a Haar cascade (.xml) or YuNet (.onnx) model run
+	// on every FaceEvery-th frame. Crops are saved next to the video.
+	FaceModel string
+	FaceEvery int
+
+	// Overlay, if set, is stamped onto every frame before it is written.
+	Overlay *Overlay
+	// Live, if set, is offered every frame so the preview keeps running.
+	Live *LiveView
+
+	// SealTo, if set, encrypts the finished video to this key and removes
+	// the plaintext; Path is then the .age file. The snapshot and face crops
+	// stay plaintext so they can be sent as photos.
+	SealTo age.Recipient
+	// UploadLimit, with SealTo, is the smallest notifier upload limit. A
+	// video over it also gets sealed copies that fit; see sealUploadCopies.
+	UploadLimit int64
+
+	// Stop, when closed, ends the recording early; what was recorded so far
+	// is finalized as usual.
+	Stop <-chan struct{}
+
+	// Logf receives progress messages; nil discards them.
+	Logf func(format string, args ...any)
+}
+
+// videosDir is config.OutputDir, or ~/iseeyougo/videos if unset.
+func videosDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("find home dir: %w", err)
+	}
+	dir := currentConfig().OutputDir
+	switch {
+	case dir == "":
+		return filepath.Join(home, "iseeyougo", "videos"), nil
+	case dir == "~":
+		return home, nil
+	case strings.HasPrefix(dir, "~/"):
+		return filepath.Join(home, dir[2:]), nil
+	}
+	return filepath.Abs(dir)
+}
+
+// cameraParams returns the size and frame rate to use for d, filling in
+// defaults for anything enumerate could not determine.
+func cameraParams(d Device) (w, h, fps int) {
+	w, h = int(d.Width), int(d.Height)
+	if w == 0 || h == 0 {
+		w, h = 1280, 720
+	}
+	fps = d.FPS
+	if fps <= 0 {
+		fps = 30
+	}
+	return w, h, fps
+}
+
+func closeMats(mats []gocv.Mat) {
+	for i := range mats {
+		mats[i].Close()
+	}
+}
+
+func (r *Recorder) logf(format string, args ...any) {
+	if r.Logf != nil {
+		r.Logf(format, args...)
+	}
+}
+
+// Record blocks until the recording is finished. Frames that could not be
+// read or written count as dropped rather than failing the recording.
+func (r *Recorder) Record() (rec Recording) {
+	rec.Start = time.Now()
+	rec.Device, rec.Trigger = r.Device, r.Trigger
+	defer func() {
+		if rec.End.IsZero() {
+			rec.End = time.Now()
+		}
+		if secs := rec.End.Sub(rec.Start).Seconds(); secs > 0 {
+			rec.FPS = float64(rec.Frames-rec.PreRollFrames) / secs
+		}
+	}()
+
+	src, w, h, fps, frames, times := r.PreRoll.Detach()
+	defer r.PreRoll.Release()
+	defer closeMats(frames)
+
+	var err error
+	if src == nil {
+		src, w, h, fps, err = openSource(r.Device)
+		if err != nil {
+			rec.Err = err
+			return rec
+		}
+	}
+	defer src.Close()
+	rec.Width, rec.Height, rec.SourceFPS = w, h, fps
+
+	dir := r.Dir
+	if dir == "" {
+		if dir, err = videosDir(); err != nil {
+			rec.Err = err
+			return rec
+		}
+	}
+	_ = os.MkdirAll(dir, 0o755)
+
+	formats := r.Formats
+	if len(formats) == 0 {
+		formats = defaultVideoFormats
+	}
+	fields := NameFields{Time: rec.Start, Host: hostName(), Camera: cameraName(r.Device), Trigger: r.Trigger}
+	reserve := func(ext string) (string, func(), error) {
+		return reserveOutput(dir, r.Template, fields, ext)
+	}
+	writer, filename, release, format, err := openVideoWriter(formats, reserve, float64(fps), w, h, r.logf)
+	if err != nil {
+		rec.Err = err
+		return rec
+	}
+	rec.Format = format
+	rec.release = release
+
+	img := gocv.NewMat()
+	defer img.Close()
+
+	start := time.Now()
+	deadline := start.Add(r.Duration)
+	lastMotion := start
+	var motion *motionDetector
+	if r.Motion != nil {
+		motion = newMotionDetector(r.Motion.Threshold)
+		defer motion.Close()
+	}
+	tick := time.NewTicker(time.Second / time.Duration(fps))
+	defer tick.Stop()
+
+	r.logf("Recording %dx%d @ %dfps: %s", w, h, fps, filename)
+
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+
+	var faces *faceAnalyzer
+	if r.FaceModel != "" {
+		if faces, err = newFaceAnalyzer(r.FaceModel, r.FaceEvery, base+"_faces"); err != nil {
+			r.logf("Face detection disabled: %v", err)
+			faces = nil
+		}
+	}
+
+	if len(frames) > 0 {
+		r.logf("Writing %d pre-roll frames", len(frames))
+	}
+	for i, f := range frames {
+		if r.Overlay != nil {
+			r.Overlay.Draw(&f, rec.Frames, times[i])
+		}
+		if err := writer.Write(f); err != nil {
+			rec.Dropped++
+			continue
+		}
+		if faces != nil {
+			faces.Offer(rec.Frames, f)
+		}
+		rec.Frames++
+		rec.PreRollFrames++
+	}
+
+	var snap *snapshotPicker
+	if r.OnSnapshot != nil {
+		snap = newSnapshotPicker(r.SnapshotFrames)
+		defer func() {
+			if snap != nil {
+				snap.Close()
+			}
+		}()
+	}
+	saveSnapshot := func() {
+		path := base + ".jpg"
+		if err := snap.Save(path); err != nil {
+			r.logf("Snapshot failed: %v", err)
+		} else {
+			rec.Snapshot = path
+			go r.OnSnapshot(path)
+		}
+		snap.Close()
+		snap = nil
+	}
+
+loop:
+	for {
+		select {
+		case <-r.Stop:
+			rec.StopReason = "stopped"
+			r.logf("Stopping early, finalizing %s", filename)
+			break loop
+		case <-tick.C:
+		}
+
+		now := time.Now()
+		if motion == nil && now.After(deadline) {
+			rec.StopReason = "duration"
+			break
+		}
+		if motion != nil && now.After(deadline) && now.Sub(start) >= r.Motion.Max {
+			rec.StopReason = "max"
+			break
+		}
+		if motion != nil && now.After(deadline) && now.Sub(lastMotion) >= r.Motion.Idle {
+			rec.StopReason = "idle"
+			break
+		}
+		if ok := src.Read(&img); !ok || img.Empty() {
+			rec.Dropped++
+			continue
+		}
+		// Before the overlay, whose changing digits would count as motion.
+		if motion != nil && motion.Moving(img) {
+			lastMotion = now
+		}
+		if r.Overlay != nil {
+			r.Overlay.Draw(&img, rec.Frames, time.Now())
+		}
+		r.Live.Offer(img)
+		if err := writer.Write(img); err != nil {
+			r.logf("Error writing frame: %v", err)
+			rec.Dropped++
+			continue
+		}
+		if faces != nil {
+			faces.Offer(rec.Frames, img)
+		}
+		rec.Frames++
+
+		if snap != nil && snap.Offer(img) {
+			saveSnapshot()
+		}
+	}
+	rec.End = time.Now()
+	if motion != nil {
+		r.logf("Recorded %.0fs, stopped on %s", rec.End.Sub(start).Seconds(), rec.StopReason)
+	}
+
+	// Recording ended before enough frames were seen.
+	if snap != nil && !snap.Empty() {
+		saveSnapshot()
+	}
+
+	if faces != nil {
+		if rec.Faces, err = faces.Finish(base + "_face.jpg"); err != nil {
+			r.logf("Face detection: %v", err)
+		}
+		if rec.Faces.MaxFaces > 0 {
+			r.logf("%s in %d sampled frames", facesDetected(rec.Faces.MaxFaces), len(rec.Faces.Frames))
+		}
+	}
+
+	writer.Close()
+	time.Sleep(1 * time.Second)
+
+	rec.Path = filename
+	if r.SealTo != nil {
+		if r.UploadLimit > 0 {
+			if err := sealUploadCopies(filename, r.SealTo, r.UploadLimit, r.logf); err != nil {
+				r.logf("Preparing encrypted upload copies failed: %v", err)
+			}
+		}
+		sealed, err := sealFile(filename, r.SealTo)
+		if err != nil {
+			r.logf("Encryption failed, keeping plaintext: %v", err)
+		} else {
+			rec.Path = sealed
+		}
+	}
+	return rec
+}
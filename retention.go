@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMinFreeMB = 100
+	janitorInterval  = 10 * time.Minute
+)
+
+// Retention limits what is kept in the videos directory. Zero values mean
+// no limit.
+type Retention struct {
+	MaxAge   time.Duration
+	MaxBytes int64
+	MaxCount int
+	// KeepUndelivered spares recordings with uploads still in the outbox,
+	// even if that means going over the limits.
+	KeepUndelivered bool
+}
+
+func retentionFromConfig(cfg Config) Retention {
+	return Retention{
+		MaxAge:          time.Duration(cfg.RetentionDays) * 24 * time.Hour,
+		MaxBytes:        int64(cfg.RetentionMaxMB) * 1024 * 1024,
+		MaxCount:        cfg.RetentionMaxCount,
+		KeepUndelivered: cfg.KeepUndelivered,
+	}
+}
+
+func (r Retention) enabled() bool {
+	return r.MaxAge > 0 || r.MaxBytes > 0 || r.MaxCount > 0
+}
+
+// savedRecording is everything in the videos directory that belongs to one
+// recording: the video, its snapshot, face crops and upload copies.
+type savedRecording struct {
+	key   string
+	files []string
+	size  int64
+	time  time.Time // newest file
+}
+
+// derivedSuffix matches what is added to a recording's name for files made
+// from it, e.g. capture_x_face.jpg or capture_x_part2.mp4.
+var derivedSuffix = regexp.MustCompile(`_(small|part\d+|face|faces)$`)
+
+// recordingKey maps a file to its recording: the path relative to dir
+// without extension or derived suffix. Face crops map through their
+// directory.
+func recordingKey(dir, path string) string {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return path
+	}
+	rel = filepath.ToSlash(rel)
+	if parent := filepath.ToSlash(filepath.Dir(rel)); strings.HasSuffix(parent, "_faces") {
+		rel = parent
+	}
+	dirPart, name := "", rel
+	if i := strings.LastIndex(rel, "/"); i >= 0 {
+		dirPart, name = rel[:i+1], rel[i+1:]
+	}
+	if i := strings.Index(name, "."); i > 0 {
+		name = name[:i]
+	}
+	return dirPart + derivedSuffix.ReplaceAllString(name, "")
+}
+
+func listRecordings(dir string) ([]*savedRecording, error) {
+	byKey := map[string]*savedRecording{}
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		key := recordingKey(dir, path)
+		r := byKey[key]
+		if r == nil {
+			r = &savedRecording{key: key}
+			byKey[key] = r
+		}
+		r.files = append(r.files, path)
+		r.size += info.Size()
+		if info.ModTime().After(r.time) {
+			r.time = info.ModTime()
+		}
+		return nil
+	})
+
+	recs := make([]*savedRecording, 0, len(byKey))
+	for _, r := range byKey {
+		recs = append(recs, r)
+	}
+	// Newest first.
+	sort.Slice(recs, func(i, j int) bool { return recs[i].time.After(recs[j].time) })
+	return recs, err
+}
+
+// sweepMu keeps the janitor and a starting recording from sweeping at once.
+var sweepMu sync.Mutex
+
+// held counts the holds on files that retention must leave alone: a
+// recording being written and files being delivered.
+var (
+	heldMu sync.Mutex
+	held   = map[string]int{}
+)
+
+// holdFile keeps retention from deleting path's recording, with its
+// snapshot, sidecar and the copies made for upload, until release is
+// called.
+func holdFile(path string) (release func()) {
+	heldMu.Lock()
+	held[path]++
+	heldMu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			heldMu.Lock()
+			if held[path]--; held[path] <= 0 {
+				delete(held, path)
+			}
+			heldMu.Unlock()
+		})
+	}
+}
+
+// heldFiles lists the files with a hold on them.
+func heldFiles() []string {
+	heldMu.Lock()
+	defer heldMu.Unlock()
+	paths := make([]string, 0, len(held))
+	for p := range held {
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// sweepVideos deletes the oldest recordings in dir until ret is satisfied.
+// Recordings still being written or delivered are always kept.
+func sweepVideos(dir string, ret Retention, logf func(format string, args ...any)) error {
+	if !ret.enabled() {
+		return nil
+	}
+	sweepMu.Lock()
+	defer sweepMu.Unlock()
+
+	recs, err := listRecordings(dir)
+	if err != nil {
+		return err
+	}
+
+	// Held files are read after listing: a recording that reserves its file
+	// after this point isn't in recs.
+	pending := map[string]bool{}
+	for _, p := range heldFiles() {
+		pending[recordingKey(dir, p)] = true
+	}
+	if ret.KeepUndelivered {
+		for _, p := range outbox.Paths() {
+			pending[recordingKey(dir, p)] = true
+		}
+	}
+
+	var total int64
+	for _, r := range recs {
+		total += r.size
+	}
+	count := len(recs)
+	cutoff := time.Now().Add(-ret.MaxAge)
+
+	var deleted []string
+	for i := len(recs) - 1; i >= 0; i-- {
+		r := recs[i]
+		expired := ret.MaxAge > 0 && r.time.Before(cutoff)
+		overSize := ret.MaxBytes > 0 && total > ret.MaxBytes
+		overCount := ret.MaxCount > 0 && count > ret.MaxCount
+		if !expired && !overSize && !overCount {
+			continue
+		}
+		if pending[r.key] {
+			continue
+		}
+		for _, f := range r.files {
+			if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+				logf("Retention: %v", err)
+				continue
+			}
+			deleted = append(deleted, f)
+		}
+		os.Remove(filepath.Join(dir, filepath.FromSlash(r.key)+"_faces"))
+		// Drop directories a filename template created, once empty.
+		for d := filepath.Dir(filepath.Join(dir, filepath.FromSlash(r.key))); d != filepath.Clean(dir) && strings.HasPrefix(d, dir); d = filepath.Dir(d) {
+			if os.Remove(d) != nil {
+				break
+			}
+		}
+		total -= r.size
+		count--
+	}
+
+	if len(deleted) > 0 {
+		logf("Retention: removed %d file(s), %d recording(s) kept", len(deleted), count)
+		if err := appendLedgerDeleted(deleted...); err != nil {
+			logf("Ledger: %v", err)
+		}
+	}
+	return nil
+}
+
+// checkFreeSpace fails if dir's filesystem is below the configured minimum.
+func checkFreeSpace(dir string, cfg Config) error {
+	minMB := cfg.MinFreeMB
+	if minMB <= 0 {
+		minMB = defaultMinFreeMB
+	}
+	free, err := freeSpace(dir)
+	if err != nil {
+		return nil // can't tell, don't block recording
+	}
+	if free < uint64(minMB)*1024*1024 {
+		return fmt.Errorf("only %.0f MB free in %s, need %d MB (min_free_mb)", float64(free)/(1024*1024), dir, minMB)
+	}
+	return nil
+}
+
+// prepareVideosDir applies retention and checks free space before a new
+// recording.
+func prepareVideosDir(logf func(format string, args ...any)) error {
+	dir, err := videosDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	cfg := currentConfig()
+	if err := sweepVideos(dir, retentionFromConfig(cfg), logf); err != nil {
+		logf("Retention: %v", err)
+	}
+	return checkFreeSpace(dir, cfg)
+}
+
+// startJanitor applies the retention rules from config now and every
+// janitorInterval until stop is closed.
+func startJanitor(stop <-chan struct{}, logf func(format string, args ...any)) {
+	go func() {
+		tick := time.NewTicker(janitorInterval)
+		defer tick.Stop()
+		for {
+			if dir, err := videosDir(); err == nil {
+				if err := sweepVideos(dir, retentionFromConfig(currentConfig()), logf); err != nil {
+					logf("Retention: %v", err)
+				}
+			}
+			select {
+			case <-stop:
+				return
+			case <-tick.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJanitorSweepsOnStart(t *testing.T) {
+	isolateHome(t)
+	useConfig(t, Config{RetentionMaxCount: 1})
+	dir, err := videosDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	old := filepath.Join(dir, "capture_1.mp4")
+	recent := filepath.Join(dir, "capture_2.mp4")
+	for i, f := range []string{old, recent} {
+		if err := os.WriteFile(f, []byte("video"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(time.Duration(i-2) * time.Hour)
+		if err := os.Chtimes(f, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	startJanitor(stop, func(string, ...any) {})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(old); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("janitor did not remove the older recording")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Errorf("newest recording removed: %v", err)
+	}
+}
+
+func TestSweepKeepsHeldRecordings(t *testing.T) {
+	isolateHome(t)
+	dir := t.TempDir()
+	sending := filepath.Join(dir, "capture_1.mp4")
+	old := filepath.Join(dir, "capture_2.mp4")
+	for i, f := range []string{sending, old, sidecarPath(sending)} {
+		if err := os.WriteFile(f, []byte("video"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(time.Duration(i%2-3) * time.Hour)
+		if err := os.Chtimes(f, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A delivery in flight and a recording being written.
+	releaseSending := holdFile(sending)
+	writing, releaseWriting, err := reserveOutput(dir, "capture_{seq}", NameFields{}, ".mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if writing != filepath.Join(dir, "capture_3.mp4") {
+		t.Fatalf("reserved %s", writing)
+	}
+
+	ret := Retention{MaxBytes: 1}
+	logf := func(string, ...any) {}
+	if err := sweepVideos(dir, ret, logf); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		path string
+		kept bool
+	}{
+		{sending, true},
+		{sidecarPath(sending), true},
+		{old, false},
+		{writing, true},
+	} {
+		if _, err := os.Stat(tt.path); (err == nil) != tt.kept {
+			t.Errorf("%s: kept = %v, want %v", filepath.Base(tt.path), err == nil, tt.kept)
+		}
+	}
+
+	releaseSending()
+	releaseSending() // releasing twice is harmless
+	if err := sweepVideos(dir, ret, logf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(sending); !os.IsNotExist(err) {
+		t.Errorf("%s kept after its delivery finished", filepath.Base(sending))
+	}
+	if _, err := os.Stat(writing); err != nil {
+		t.Errorf("recording in progress removed: %v", err)
+	}
+	releaseWriting()
+	if len(heldFiles()) != 0 {
+		t.Errorf("holds left: %q", heldFiles())
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+)
+
+// Sealed files are age-encrypted (https://age-encryption.org) to an X25519
+// recipient, so this machine can write them but not read them back. They
+// open with the decrypt subcommand or with "age -d -i key file.age".
+const sealExt = ".age"
+
+// ParsePublicKey parses an age public key ("age1...") as printed by keygen
+// or age-keygen.
+func ParsePublicKey(s string) (*age.X25519Recipient, error) {
+	r, err := age.ParseX25519Recipient(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: want an age public key (age1...): %w", err)
+	}
+	return r, nil
+}
+
+// ReadPrivateKey reads a key file written by keygen or age-keygen.
+func ReadPrivateKey(path string) ([]age.Identity, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ids, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ids, nil
+}
+
+// GenerateKey returns a new key pair in age's text format.
+func GenerateKey() (private, public string, err error) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		return "", "", err
+	}
+	return id.String(), id.Recipient().String(), nil
+}
+
+// sealFile encrypts path to path+".age" for the recipient and removes the
+// plaintext.
+func sealFile(path string, to age.Recipient) (string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	dst := path + sealExt
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return "", err
+	}
+	if err := seal(out, in, to); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return "", fmt.Errorf("seal %s: %w", path, err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return "", err
+	}
+	in.Close()
+	if err := os.Remove(path); err != nil {
+		return dst, err
+	}
+	return dst, nil
+}
+
+func seal(w io.Writer, r io.Reader, to age.Recipient) error {
+	enc, err := age.Encrypt(w, to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(enc, r); err != nil {
+		return err
+	}
+	// Close writes the last chunk, which marks the end of the file.
+	return enc.Close()
+}
+
+// openSealed decrypts a sealed file to path without ".age".
+func openSealed(path string, keys []age.Identity) (string, error) {
+	if !strings.HasSuffix(path, sealExt) {
+		return "", fmt.Errorf("%s: not a %s file", path, sealExt)
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	dst := strings.TrimSuffix(path, sealExt)
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", err
+	}
+	if err := unseal(out, in, keys); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return "", fmt.Errorf("decrypt %s: %w", path, err)
+	}
+	return dst, out.Close()
+}
+
+var errSealCorrupt = errors.New("wrong key or corrupted file")
+
+// unseal fails with errSealCorrupt unless the whole file decrypts; w may
+// have received part of the plaintext by then.
+func unseal(w io.Writer, r io.Reader, keys []age.Identity) error {
+	dec, err := age.Decrypt(r, keys...)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errSealCorrupt, err)
+	}
+	// Not io.Copy, to tell a bad file from a failed write.
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := dec.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", errSealCorrupt, err)
+		}
+	}
+}
+
+// runKeygen writes a new private key to keyPath, in the format age-keygen
+// uses, and prints the public key for the config.
+func runKeygen(keyPath string) error {
+	private, public, err := GenerateKey()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(keyPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "# created: %s\n# public key: %s\n%s\n", time.Now().Format(time.RFC3339), public, private)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Private key written to %s - keep it off this laptop.\n", keyPath)
+	fmt.Printf("Add this to the config:\n\n  \"encrypt_public_key\": %q\n", public)
+	return nil
+}
+
+// runDecrypt decrypts each sealed file next to itself.
+func runDecrypt(keyPath string, files []string) error {
+	keys, err := ReadPrivateKey(keyPath)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, f := range files {
+		dst, err := openSealed(f, keys)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed++
+			continue
+		}
+		fmt.Println(dst)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed", failed, len(files))
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+)
+
+// testKeys returns a recipient and the identities that open its files.
+func testKeys(t *testing.T) (*age.X25519Recipient, []age.Identity) {
+	t.Helper()
+	private, public, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := ParsePublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := age.ParseIdentities(strings.NewReader(private))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return to, ids
+}
+
+func sealBytes(t *testing.T, plain []byte, to age.Recipient) []byte {
+	t.Helper()
+	var sealed bytes.Buffer
+	if err := seal(&sealed, bytes.NewReader(plain), to); err != nil {
+		t.Fatal(err)
+	}
+	return sealed.Bytes()
+}
+
+// Payload chunks are 64 KiB of plaintext plus a 16 byte tag, after the
+// header and a 16 byte nonce.
+const (
+	ageChunk    = 64*1024 + 16
+	ageNonceLen = 16
+)
+
+// ageBody returns where the first payload chunk starts.
+func ageBody(t *testing.T, sealed []byte) int {
+	t.Helper()
+	i := bytes.Index(sealed, []byte("\n--- "))
+	if i < 0 {
+		t.Fatal("no header MAC line")
+	}
+	end := bytes.IndexByte(sealed[i+1:], '\n')
+	return i + 1 + end + 1 + ageNonceLen
+}
+
+func TestSealRoundTrip(t *testing.T) {
+	to, ids := testKeys(t)
+	for _, size := range []int{0, 1, 64 * 1024, 200 * 1024} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+		sealed := sealBytes(t, plain, to)
+		if size >= 1024 && bytes.Contains(sealed, plain[:1024]) {
+			t.Errorf("%d bytes: plaintext in the sealed file", size)
+		}
+
+		var got bytes.Buffer
+		if err := unseal(&got, bytes.NewReader(sealed), ids); err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if !bytes.Equal(got.Bytes(), plain) {
+			t.Errorf("%d bytes: got %d bytes back, not the plaintext", size, got.Len())
+		}
+	}
+}
+
+func TestSealFile(t *testing.T) {
+	dir := t.TempDir()
+	video := filepath.Join(dir, "capture_1.mp4")
+	if err := os.WriteFile(video, []byte("frames"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "iseeyougo.key")
+	if err := runKeygen(keyPath); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := ReadPrivateKey(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The key file names its public key, as age-keygen's does.
+	data, _ := os.ReadFile(keyPath)
+	public := strings.TrimPrefix(strings.Split(string(data), "\n")[1], "# public key: ")
+	to, err := ParsePublicKey(public)
+	if err != nil {
+		t.Fatalf("public key line %q: %v", public, err)
+	}
+
+	sealed, err := sealFile(video, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sealed != video+sealExt {
+		t.Errorf("sealed to %s", sealed)
+	}
+	if _, err := os.Stat(video); !os.IsNotExist(err) {
+		t.Errorf("plaintext left behind: %v", err)
+	}
+
+	opened, err := openSealed(sealed, ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(opened); opened != video || string(got) != "frames" {
+		t.Errorf("decrypted to %s: %q", opened, got)
+	}
+	if _, err := openSealed(sealed, ids); err == nil {
+		t.Error("decrypting again overwrote the plaintext")
+	}
+}
+
+func TestUnsealRejects(t *testing.T) {
+	to, ids := testKeys(t)
+	_, other := testKeys(t)
+	plain := make([]byte, 3*64*1024+100) // three full chunks and a short one
+	rand.Read(plain)
+	sealed := sealBytes(t, plain, to)
+	body := ageBody(t, sealed)
+	if n := len(sealed) - body; n != 3*ageChunk+100+16 {
+		t.Fatalf("payload is %d bytes, the chunk layout changed", n)
+	}
+
+	chunk := func(i int) []byte { return sealed[body+i*ageChunk : body+(i+1)*ageChunk] }
+	reordered := append(append(append(append([]byte{}, sealed[:body]...), chunk(1)...), chunk(0)...), sealed[body+2*ageChunk:]...)
+	tampered := append([]byte{}, sealed...)
+	tampered[body+10] ^= 1
+
+	tests := []struct {
+		name   string
+		sealed []byte
+		keys   []age.Identity
+	}{
+		{"wrong key", sealed, other},
+		{"truncated final chunk", sealed[:len(sealed)-50], ids},
+		{"final chunk dropped", sealed[:body+3*ageChunk], ids},
+		{"reordered chunks", reordered, ids},
+		{"flipped bit", tampered, ids},
+		{"trailing data", append(append([]byte{}, sealed...), 0), ids},
+		{"empty file", nil, ids},
+		{"not sealed", plain, ids},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := unseal(new(bytes.Buffer), bytes.NewReader(tt.sealed), tt.keys)
+			if !errors.Is(err, errSealCorrupt) {
+				t.Errorf("unseal() = %v, want %v", err, errSealCorrupt)
+			}
+		})
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	_, public, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, _, _ := GenerateKey()
+	for _, tt := range []struct {
+		key string
+		ok  bool
+	}{
+		{public, true},
+		{" " + public + "\n", true},
+		{"", false},
+		{"not-a-key", false},
+		{private, false},
+		{public[:len(public)-1], false},
+	} {
+		if _, err := ParsePublicKey(tt.key); (err == nil) != tt.ok {
+			t.Errorf("ParsePublicKey(%q) = %v, want ok %v", tt.key, err, tt.ok)
+		}
+	}
+}
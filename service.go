@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+const (
+	serviceName  = "iseeyougo"
+	launchdLabel = "com.github.heikkilu.iseeyougo"
+)
+
+// commandRunner runs service manager commands such as systemctl; tests can
+// swap serviceRunner for a fake.
+type commandRunner interface {
+	Run(name string, args ...string) (output string, err error)
+}
+
+type execRunner struct{}
+
+func (execRunner) Run(name string, args ...string) (string, error) {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
+
+var serviceRunner commandRunner = execRunner{}
+
+// serviceSpec is what the unit templates are rendered from.
+type serviceSpec struct {
+	Exec    string   // absolute path of this binary
+	Args    []string // daemon and its flags
+	Label   string
+	LogPath string
+}
+
+var systemdUnit = template.Must(template.New("systemd").Funcs(template.FuncMap{"quote": systemdQuote}).Parse(`[Unit]
+Description=IseeYouGo laptop lid monitor
+After=graphical-session.target
+
+[Service]
+ExecStart={{quote .Exec}}{{range .Args}} {{quote .}}{{end}}
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=on-failure
+TimeoutStopSec=60
+
+[Install]
+WantedBy=default.target
+`))
+
+var launchdPlist = template.Must(template.New("launchd").Funcs(template.FuncMap{"xml": xmlEscape}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{xml .Label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>{{xml .Exec}}</string>
+{{- range .Args}}
+		<string>{{xml .}}</string>
+{{- end}}
+	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>StandardOutPath</key>
+	<string>{{xml .LogPath}}</string>
+	<key>StandardErrorPath</key>
+	<string>{{xml .LogPath}}</string>
+</dict>
+</plist>
+`))
+
+// systemdQuote quotes s for ExecStart if it needs it.
+func systemdQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\$%;") {
+		return s
+	}
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", "$$", "%", "%%").Replace(s)
+	return `"` + s + `"`
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// serviceTarget is where the unit goes and how it is rendered on this OS.
+type serviceTarget struct {
+	path string
+	tmpl *template.Template
+}
+
+func currentServiceTarget() (serviceTarget, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return serviceTarget{}, err
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		return serviceTarget{filepath.Join(home, "Library", "LaunchAgents", launchdLabel+".plist"), launchdPlist}, nil
+	case "linux":
+		dir := os.Getenv("XDG_CONFIG_HOME")
+		if dir == "" {
+			dir = filepath.Join(home, ".config")
+		}
+		return serviceTarget{filepath.Join(dir, "systemd", "user", serviceName+".service"), systemdUnit}, nil
+	}
+	return serviceTarget{}, fmt.Errorf("services are not supported on %s", runtime.GOOS)
+}
+
+func launchdDomain() string {
+	return "gui/" + strconv.Itoa(os.Getuid())
+}
+
+// runService implements
+//
+//	iseeyougo service install [-print] [-- daemon flags]
+//	iseeyougo service uninstall
+//	iseeyougo service status
+func runService(args []string) error {
+	usage := errors.New("usage: iseeyougo service install [-print] [-- daemon flags] | uninstall | status")
+	if len(args) == 0 {
+		return usage
+	}
+	target, err := currentServiceTarget()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "install":
+		fs := flag.NewFlagSet("service install", flag.ExitOnError)
+		printOnly := fs.Bool("print", false, "Print the unit instead of installing it")
+		fs.Parse(args[1:])
+		return installService(target, fs.Args(), *printOnly)
+	case "uninstall":
+		return uninstallService(target)
+	case "status":
+		return serviceStatus(target)
+	}
+	return usage
+}
+
+// checkDaemonArgs parses args the way the daemon will, so a typo fails now
+// rather than in a restart loop under the service manager.
+func checkDaemonArgs(args []string) error {
+	f, _, _ := newMonitorFlags("daemon")
+	f.fs.Init("daemon", flag.ContinueOnError)
+	if err := f.read(args); err != nil {
+		return fmt.Errorf("daemon flags: %w", err)
+	}
+	if f.fs.NArg() > 0 {
+		return fmt.Errorf("daemon flags: unexpected argument %q", f.fs.Arg(0))
+	}
+	var cfg Config
+	f.apply(&cfg)
+	return cfg.validate()
+}
+
+func installService(target serviceTarget, daemonArgs []string, printOnly bool) error {
+	if err := checkDaemonArgs(daemonArgs); err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	spec := serviceSpec{
+		Exec:    exe,
+		Args:    append([]string{"daemon"}, daemonArgs...),
+		Label:   launchdLabel,
+		LogPath: filepath.Join(configDir(), serviceName+".log"),
+	}
+	var unit bytes.Buffer
+	if err := target.tmpl.Execute(&unit, spec); err != nil {
+		return err
+	}
+	if printOnly {
+		fmt.Print(unit.String())
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target.path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(target.path, unit.Bytes(), 0o644); err != nil {
+		return err
+	}
+	fmt.Println("Wrote", target.path)
+
+	if runtime.GOOS == "darwin" {
+		// bootout fails if it wasn't loaded, which is fine.
+		serviceRunner.Run("launchctl", "bootout", launchdDomain(), target.path)
+		return runServiceCommand("launchctl", "bootstrap", launchdDomain(), target.path)
+	}
+	if err := runServiceCommand("systemctl", "--user", "daemon-reload"); err != nil {
+		return err
+	}
+	return runServiceCommand("systemctl", "--user", "enable", "--now", serviceName+".service")
+}
+
+func uninstallService(target serviceTarget) error {
+	if _, err := os.Stat(target.path); errors.Is(err, os.ErrNotExist) {
+		fmt.Println("Not installed:", target.path)
+		return nil
+	}
+	if runtime.GOOS == "darwin" {
+		serviceRunner.Run("launchctl", "bootout", launchdDomain(), target.path)
+	} else if err := runServiceCommand("systemctl", "--user", "disable", "--now", serviceName+".service"); err != nil {
+		return err
+	}
+	if err := os.Remove(target.path); err != nil {
+		return err
+	}
+	fmt.Println("Removed", target.path)
+	if runtime.GOOS != "darwin" {
+		return runServiceCommand("systemctl", "--user", "daemon-reload")
+	}
+	return nil
+}
+
+func serviceStatus(target serviceTarget) error {
+	if _, err := os.Stat(target.path); errors.Is(err, os.ErrNotExist) {
+		fmt.Println("Not installed:", target.path)
+		return nil
+	}
+	fmt.Println("Installed:", target.path)
+
+	// Both report a stopped service with a non-zero exit; the output says
+	// what is going on either way.
+	var out string
+	if runtime.GOOS == "darwin" {
+		out, _ = serviceRunner.Run("launchctl", "print", launchdDomain()+"/"+launchdLabel)
+	} else {
+		out, _ = serviceRunner.Run("systemctl", "--user", "status", "--no-pager", serviceName+".service")
+	}
+	if out != "" {
+		fmt.Println(out)
+	}
+	return nil
+}
+
+func runServiceCommand(name string, args ...string) error {
+	out, err := serviceRunner.Run(name, args...)
+	if out != "" {
+		fmt.Println(out)
+	}
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", name, strings.Join(args, " "), err)
+	}
+	return nil
+}
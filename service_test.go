@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeRunner records service manager commands instead of running them.
+// Commands listed in fail return an error.
+type fakeRunner struct {
+	calls []string
+	fail  map[string]bool
+}
+
+func (r *fakeRunner) Run(name string, args ...string) (string, error) {
+	cmd := strings.Join(append([]string{name}, args...), " ")
+	r.calls = append(r.calls, cmd)
+	if r.fail[cmd] {
+		return "Failed to connect to bus", errors.New("exit status 1")
+	}
+	return "", nil
+}
+
+// useRunner swaps serviceRunner for a fake for one test.
+func useRunner(t *testing.T, r commandRunner) {
+	t.Helper()
+	old := serviceRunner
+	serviceRunner = r
+	t.Cleanup(func() { serviceRunner = old })
+}
+
+// systemdTarget is a unit path under a temporary directory.
+func systemdTarget(t *testing.T) serviceTarget {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skip("systemd units are installed on Linux only")
+	}
+	isolateHome(t)
+	return serviceTarget{filepath.Join(t.TempDir(), "systemd", "user", serviceName+".service"), systemdUnit}
+}
+
+func TestSystemdQuote(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"/usr/local/bin/iseeyougo", "/usr/local/bin/iseeyougo"},
+		{"-duration", "-duration"},
+		{"/home/me/My Videos", `"/home/me/My Videos"`},
+		{"capture_%Y", `"capture_%%Y"`},
+		{"100% done", `"100%% done"`},
+		{"$HOME", `"$$HOME"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`C:\videos`, `"C:\\videos"`},
+		{"", `""`},
+	}
+	for _, tt := range tests {
+		if got := systemdQuote(tt.in); got != tt.want {
+			t.Errorf("systemdQuote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSystemdUnitQuotesPaths(t *testing.T) {
+	spec := serviceSpec{
+		Exec: "/opt/my apps/iseeyougo",
+		Args: []string{"daemon", "-output-dir", "/home/me/My Videos", "-template", "lid_%H"},
+	}
+	var unit bytes.Buffer
+	if err := systemdUnit.Execute(&unit, spec); err != nil {
+		t.Fatal(err)
+	}
+	want := `ExecStart="/opt/my apps/iseeyougo" daemon -output-dir "/home/me/My Videos" -template "lid_%%H"`
+	if !strings.Contains(unit.String(), want+"\n") {
+		t.Errorf("unit has no line\n%s\ngot:\n%s", want, unit.String())
+	}
+}
+
+func TestInstallServiceSystemd(t *testing.T) {
+	target := systemdTarget(t)
+	r := &fakeRunner{}
+	useRunner(t, r)
+
+	if err := installService(target, []string{"-duration", "30s", "-output-dir", "/home/me/My Videos"}, false); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"systemctl --user daemon-reload",
+		"systemctl --user enable --now iseeyougo.service",
+	}
+	if !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("ran %q, want %q", r.calls, want)
+	}
+	unit, err := os.ReadFile(target.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(unit), ` daemon -duration 30s -output-dir "/home/me/My Videos"`+"\n") {
+		t.Errorf("ExecStart doesn't pass the daemon flags:\n%s", unit)
+	}
+}
+
+func TestInstallServiceStopsOnFailure(t *testing.T) {
+	target := systemdTarget(t)
+	r := &fakeRunner{fail: map[string]bool{"systemctl --user daemon-reload": true}}
+	useRunner(t, r)
+
+	if err := installService(target, nil, false); err == nil {
+		t.Fatal("installService() succeeded with daemon-reload failing")
+	}
+	if want := []string{"systemctl --user daemon-reload"}; !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("ran %q, want only %q", r.calls, want)
+	}
+}
+
+func TestInstallServiceRejectsBadDaemonFlags(t *testing.T) {
+	target := systemdTarget(t)
+	for _, args := range [][]string{
+		{"-no-such-flag"},
+		{"-duration", "soon"},
+		{"-encrypt-to", "not-a-key"},
+		{"stray"},
+	} {
+		r := &fakeRunner{}
+		useRunner(t, r)
+		if err := installService(target, args, false); err == nil {
+			t.Errorf("installService(%q) succeeded", args)
+		}
+		if len(r.calls) != 0 {
+			t.Errorf("installService(%q) ran %q", args, r.calls)
+		}
+		if _, err := os.Stat(target.path); !os.IsNotExist(err) {
+			t.Fatalf("installService(%q) wrote the unit", args)
+		}
+	}
+}
+
+func TestUninstallServiceSystemd(t *testing.T) {
+	target := systemdTarget(t)
+	r := &fakeRunner{}
+	useRunner(t, r)
+	if err := installService(target, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	r.calls = nil
+
+	if err := uninstallService(target); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"systemctl --user disable --now iseeyougo.service",
+		"systemctl --user daemon-reload",
+	}
+	if !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("ran %q, want %q", r.calls, want)
+	}
+	if _, err := os.Stat(target.path); !os.IsNotExist(err) {
+		t.Errorf("unit still there: %v", err)
+	}
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// ErrBusy is returned when the camera is already in use by a recording or
+// snapshot.
+var ErrBusy = errors.New("camera is busy")
+
+// ErrShuttingDown is returned for recordings requested after Shutdown.
+var ErrShuttingDown = errors.New("shutting down")
+
+// Session is one monitored camera: the lid monitor, the recording settings
+// and pre-roll, and what was recorded last. The CLI and the GUI each drive
+// one, and remote controls such as bot commands act on the same session.
+type Session struct {
+	Device   Device
+	Duration time.Duration
+	// Motion, if set, lets lid recordings run past Duration while there is
+	// motion. Bot clips always have a fixed length.
+	Motion  *MotionGate
+	Monitor *Monitor
+	PreRoll *PreRoll
+	Live    *LiveView
+	Logf    func(format string, args ...any)
+	// Events records monitor events and recordings for the API.
+	Events *eventFeed
+
+	mu     sync.Mutex
+	busy   bool
+	idle   *sync.Cond // signalled when busy is cleared
+	last   Recording
+	hasRec bool
+	// recTrigger and preempt belong to the recording in progress, if any;
+	// closing preempt stops it early.
+	recTrigger string
+	preempt    chan struct{}
+
+	// stopping is closed by Shutdown; jobs are the recordings and
+	// deliveries it waits for.
+	stopping chan struct{}
+	stopOnce sync.Once
+	jobs     sync.WaitGroup
+}
+
+func NewSession(dev Device, dur time.Duration, sensor LidSensor) *Session {
+	s := &Session{
+		Device:   dev,
+		Duration: dur,
+		Monitor:  NewMonitor(sensor),
+		PreRoll:  newPreRoll(dev, currentConfig()),
+		Live:     newLiveView(),
+		Logf:     func(string, ...any) {},
+		Events:   newEventFeed(),
+		stopping: make(chan struct{}),
+	}
+	s.idle = sync.NewCond(&s.mu)
+	s.Monitor.OnEvent = func(ev MonitorEvent) { s.Events.publish(monitorEvent(ev)) }
+	s.PreRoll.Live = s.Live
+	return s
+}
+
+// Go runs f in the background as work that Shutdown waits for, such as a
+// recording and its delivery. Once Shutdown has begun it refuses with
+// ErrShuttingDown instead.
+func (s *Session) Go(f func()) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.stopping:
+		return ErrShuttingDown
+	default:
+	}
+	s.jobs.Add(1)
+	go func() {
+		defer s.jobs.Done()
+		f()
+	}()
+	return nil
+}
+
+// Shutdown ends a recording in progress early, keeping what was recorded,
+// refuses new ones, and waits up to timeout for background work. It reports
+// whether everything finished in time.
+func (s *Session) Shutdown(timeout time.Duration) bool {
+	s.stopOnce.Do(func() {
+		// Under mu so no Go call adds a job while jobs.Wait runs.
+		s.mu.Lock()
+		close(s.stopping)
+		s.mu.Unlock()
+	})
+
+	done := make(chan struct{})
+	go func() {
+		s.jobs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// SetMotion replaces the motion gate for the next recordings.
+func (s *Session) SetMotion(m *MotionGate) {
+	s.mu.Lock()
+	s.Motion = m
+	s.mu.Unlock()
+}
+
+// acquire claims the camera for trigger. A lid trigger doesn't give way to
+// a clip or API recording: it stops it and waits for the camera instead.
+func (s *Session) acquire(trigger string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.busy {
+		if trigger != TriggerLid || s.recTrigger == TriggerLid {
+			return ErrBusy
+		}
+		if s.preempt != nil {
+			s.Logf("Lid opened, stopping the %s recording", s.recTrigger)
+			close(s.preempt)
+			s.preempt = nil
+		}
+		s.idle.Wait()
+	}
+	s.busy = true
+	s.recTrigger = trigger
+	return nil
+}
+
+func (s *Session) release() {
+	s.mu.Lock()
+	s.busy = false
+	s.recTrigger, s.preempt = "", nil
+	s.mu.Unlock()
+	s.idle.Broadcast()
+}
+
+// Trigger sources passed to Session.Record.
+const (
+	TriggerLid    = "lid"
+	TriggerClip   = "clip"
+	TriggerManual = "manual" // the record subcommand
+	TriggerAPI    = "api"
+)
+
+// Record records dur of video now, or Duration if dur is 0. For lid
+// triggers a snapshot is saved next to the video and sent to every notifier
+// while the video is still being recorded. A lid trigger cuts a clip or API
+// recording in progress short rather than fail with ErrBusy. The recording
+// is held from retention until the caller, done delivering it, calls
+// rec.Release.
+func (s *Session) Record(dur time.Duration, trigger string) Recording {
+	select {
+	case <-s.stopping:
+		return Recording{Err: ErrShuttingDown}
+	default:
+	}
+	if err := s.acquire(trigger); err != nil {
+		return Recording{Err: err}
+	}
+	defer s.release()
+
+	// stop ends the recording on Shutdown or when a lid trigger preempts it.
+	preempt := make(chan struct{})
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	s.mu.Lock()
+	s.preempt = preempt
+	s.mu.Unlock()
+	go func() {
+		select {
+		case <-s.stopping:
+		case <-preempt:
+		case <-done:
+		}
+		close(stop)
+	}()
+
+	if err := prepareVideosDir(s.Logf); err != nil {
+		return Recording{Err: err}
+	}
+	if dur <= 0 {
+		dur = s.Duration
+	}
+	// A reload mid-recording applies to the next one.
+	cfg := currentConfig()
+	r := &Recorder{
+		Device:   s.Device,
+		Duration: dur,
+		Template: cfg.FilenameTemplate,
+		Trigger:  trigger,
+		Formats:  videoFormats(cfg, s.Logf),
+		PreRoll:  s.PreRoll,
+		Live:     s.Live,
+		Stop:     stop,
+		Logf:     s.Logf,
+
+		FaceModel: cfg.FaceModel,
+		FaceEvery: cfg.FaceSampleEvery,
+		Overlay:   overlayFromConfig(cfg, trigger),
+	}
+	if cfg.EncryptPublicKey != "" {
+		// Config loading rejects a bad key; if one gets through anyway, a
+		// plaintext recording beats none.
+		if key, err := ParsePublicKey(cfg.EncryptPublicKey); err != nil {
+			s.Logf("WARNING: encrypt_public_key is invalid (%v), this recording is NOT encrypted", err)
+		} else {
+			r.SealTo = key
+			r.UploadLimit = uploadLimit(activeNotifiers())
+		}
+	}
+	// snapSent gets the snapshot deliveries, which usually finish before
+	// the sidecar they belong in is written.
+	snapSent := make(chan []Delivery, 1)
+	if trigger == TriggerLid {
+		s.mu.Lock()
+		r.Motion = s.Motion
+		s.mu.Unlock()
+		r.SnapshotFrames = cfg.SnapshotFrames
+		r.OnSnapshot = func(path string) {
+			var ds []Delivery
+			if len(activeNotifiers()) > 0 {
+				caption := fmt.Sprintf("Laptop lid opened - %s", time.Now().Format("Jan 2, 15:04:05"))
+				// The snapshot shares the video's base name, and so its sidecar.
+				ds = deliverPhoto(path, caption, sidecarPath(path), s.Logf)
+			}
+			snapSent <- ds
+		}
+	}
+	s.Events.publish(Event{Time: time.Now(), Kind: "recording_started", Trigger: trigger})
+	rec := r.Record()
+	if rec.Err != nil {
+		s.Events.publish(Event{Time: time.Now(), Kind: "recording_failed", Trigger: trigger, Error: rec.Err.Error()})
+	} else {
+		if err := appendLedger(rec.Files()...); err != nil {
+			s.Logf("Ledger: %v", err)
+		}
+		if err := writeSidecar(rec); err != nil {
+			s.Logf("Sidecar: %v", err)
+		} else if rec.Snapshot != "" {
+			note := func() { s.noteDeliveries(rec, "photo", <-snapSent) }
+			if s.Go(note) != nil {
+				note() // shutting down; this recording is a job it waits for
+			}
+		}
+		s.mu.Lock()
+		s.last, s.hasRec = rec, true
+		s.mu.Unlock()
+		s.Events.publish(Event{Time: time.Now(), Kind: "recording_saved", Trigger: trigger, Recording: recordingID(rec.Path)})
+	}
+	return rec
+}
+
+// Deliver sends a finished recording to every notifier: the best face
+// crop first if any faces were found, then the video.
+func (s *Session) Deliver(rec Recording) {
+	what := "Laptop lid opened"
+	switch rec.Trigger {
+	case TriggerClip:
+		what = "Clip"
+	case TriggerManual, TriggerAPI:
+		what = "Recording"
+	}
+	caption := fmt.Sprintf("%s - %s", what, rec.Start.Format("Jan 2, 15:04:05"))
+	if rec.Faces.MaxFaces > 0 {
+		faces := facesDetected(rec.Faces.MaxFaces)
+		caption += "\n" + faces
+		if rec.Faces.Best != "" {
+			s.noteDeliveries(rec, "photo", deliverPhoto(rec.Faces.Best, faces, sidecarPath(rec.Path), s.Logf))
+		}
+	}
+	s.noteDeliveries(rec, "video", deliverVideo(rec.Path, caption, s.Logf))
+}
+
+// noteDeliveries adds delivery results to the recording's sidecar.
+func (s *Session) noteDeliveries(rec Recording, kind string, ds []Delivery) {
+	if err := addDeliveries(rec.Path, kind, ds); err != nil {
+		s.Logf("Sidecar: %v", err)
+	}
+}
+
+// LastRecording returns the most recent recording of this session, or
+// failing that the newest capture in the videos directory.
+func (s *Session) LastRecording() (string, bool) {
+	s.mu.Lock()
+	last, ok := s.last, s.hasRec
+	s.mu.Unlock()
+	if ok {
+		return last.Path, true
+	}
+
+	dir, err := videosDir()
+	if err != nil {
+		return "", false
+	}
+	recs, _ := listRecordings(dir)
+	for _, r := range recs {
+		for _, f := range r.files {
+			if isVideoFile(f) && !uploadCopy.MatchString(f) {
+				return f, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Snap saves a single frame as a JPEG in the videos directory. While
+// pre-roll is buffering, its newest frame is used instead of reopening the
+// camera.
+func (s *Session) Snap() (string, error) {
+	if err := s.acquire("snap"); err != nil {
+		return "", err
+	}
+	defer s.release()
+
+	if err := prepareVideosDir(s.Logf); err != nil {
+		return "", err
+	}
+
+	resume := s.PreRoll.Buffering()
+	src, _, _, _, frames, _ := s.PreRoll.Detach()
+	defer func() {
+		s.PreRoll.Release()
+		if resume {
+			s.PreRoll.Start()
+		}
+	}()
+	defer closeMats(frames)
+
+	img := gocv.NewMat()
+	defer img.Close()
+	if len(frames) > 0 {
+		frames[len(frames)-1].CopyTo(&img)
+	}
+
+	if img.Empty() {
+		if src == nil {
+			var err error
+			if src, _, _, _, err = openSource(s.Device); err != nil {
+				return "", err
+			}
+		}
+		// Give auto exposure a few frames to settle.
+		frame := gocv.NewMat()
+		defer frame.Close()
+		for i := 0; i < 10; i++ {
+			if src.Read(&frame) && !frame.Empty() {
+				frame.CopyTo(&img)
+			}
+		}
+	}
+	if src != nil {
+		src.Close()
+	}
+	if img.Empty() {
+		return "", fmt.Errorf("no frame from %s", s.Device.Label())
+	}
+
+	dir, err := videosDir()
+	if err != nil {
+		return "", err
+	}
+	fields := NameFields{Time: time.Now(), Host: hostName(), Camera: cameraName(s.Device), Trigger: "snap"}
+	path, release, err := reserveOutput(dir, "snap_{time}", fields, ".jpg")
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	if !gocv.IMWrite(path, img) {
+		os.Remove(path)
+		return "", fmt.Errorf("cannot write %s", path)
+	}
+	if err := appendLedger(path); err != nil {
+		s.Logf("Ledger: %v", err)
+	}
+	return path, nil
+}
+
+// SessionStatus is a point-in-time summary for status displays.
+type SessionStatus struct {
+	State       MonitorState
+	Paused      bool
+	Busy        bool
+	LastTrigger time.Time
+	LastPath    string
+	VideoFiles  int
+	VideoBytes  int64
+	FreeBytes   uint64
+	Pending     int
+}
+
+func (s *Session) Status() SessionStatus {
+	st := SessionStatus{
+		State:       s.Monitor.State(),
+		Paused:      s.Monitor.Paused(),
+		LastTrigger: s.Monitor.LastTrigger(),
+		Pending:     outbox.Len(),
+	}
+	s.mu.Lock()
+	st.Busy = s.busy
+	if s.hasRec {
+		st.LastPath = s.last.Path
+	}
+	s.mu.Unlock()
+
+	if dir, err := videosDir(); err == nil {
+		st.VideoFiles, st.VideoBytes = dirUsage(dir)
+		st.FreeBytes, _ = freeSpace(dir)
+	}
+	return st
+}
+
+func (st SessionStatus) String() string {
+	var b strings.Builder
+	state := st.State.String()
+	if st.Paused {
+		state = "disarmed (paused)"
+	}
+	fmt.Fprintf(&b, "State: %s\n", state)
+	if st.Busy {
+		b.WriteString("Camera: recording\n")
+	}
+	if st.LastTrigger.IsZero() {
+		b.WriteString("Last trigger: never\n")
+	} else {
+		fmt.Fprintf(&b, "Last trigger: %s\n", st.LastTrigger.Format("Jan 2, 15:04:05"))
+	}
+	fmt.Fprintf(&b, "Videos: %d files, %.1f MB\n", st.VideoFiles, float64(st.VideoBytes)/(1024*1024))
+	if st.FreeBytes > 0 {
+		fmt.Fprintf(&b, "Free disk: %.1f GB\n", float64(st.FreeBytes)/(1024*1024*1024))
+	}
+	if st.Pending > 0 {
+		fmt.Fprintf(&b, "Upload queue: %d pending\n", st.Pending)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// dirUsage counts the regular files under dir and their total size.
+func dirUsage(dir string) (files int, size int64) {
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			files++
+			size += info.Size()
+		}
+		return nil
+	})
+	return files, size
+}
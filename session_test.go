@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// isolateHome points the home and config directories at temporary ones so
+// recordings, the ledger and sidecars stay out of the real home directory.
+func isolateHome(t *testing.T) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+}
+
+// testSession is a session on a small test pattern with no lid sensor.
+func testSession(t *testing.T) *Session {
+	t.Helper()
+	isolateHome(t)
+	s := NewSession(Device{Id: -1, Source: "test:pattern?size=64x48&fps=10", FPS: 10}, time.Second, nil)
+	s.Logf = t.Logf
+	return s
+}
+
+// waitBusy waits until s has claimed the camera.
+func waitBusy(t *testing.T, s *Session) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !s.Status().Busy {
+		if time.Now().After(deadline) {
+			t.Fatal("session never started recording")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLidPreemptsClip(t *testing.T) {
+	s := testSession(t)
+
+	clip := make(chan Recording, 1)
+	go func() { clip <- s.Record(time.Minute, TriggerClip) }()
+	waitBusy(t, s)
+
+	start := time.Now()
+	lid := s.Record(time.Second, TriggerLid)
+	if lid.Err != nil {
+		t.Fatalf("lid Record() during a clip: %v", lid.Err)
+	}
+	if wait := time.Since(start); wait > 30*time.Second {
+		t.Errorf("lid trigger waited %v for the clip", wait)
+	}
+
+	select {
+	case rec := <-clip:
+		if rec.Err != nil {
+			t.Fatalf("clip: %v", rec.Err)
+		}
+		if rec.StopReason != "stopped" {
+			t.Errorf("clip StopReason = %q, want stopped", rec.StopReason)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("clip still recording after the lid trigger")
+	}
+}
+
+func TestClipDoesNotPreempt(t *testing.T) {
+	s := testSession(t)
+
+	first := make(chan Recording, 1)
+	go func() { first <- s.Record(2*time.Second, TriggerLid) }()
+	waitBusy(t, s)
+
+	for _, trigger := range []string{TriggerClip, TriggerAPI, TriggerLid} {
+		if rec := s.Record(time.Second, trigger); !errors.Is(rec.Err, ErrBusy) {
+			t.Errorf("%s Record() during a lid recording = %v, want ErrBusy", trigger, rec.Err)
+		}
+	}
+	if rec := <-first; rec.Err != nil || rec.StopReason != "duration" {
+		t.Errorf("lid recording = %q, %v; want it to run its full length", rec.StopReason, rec.Err)
+	}
+}
+
+func TestRecordAfterShutdown(t *testing.T) {
+	s := testSession(t)
+	if !s.Shutdown(time.Second) {
+		t.Fatal("Shutdown() timed out with nothing running")
+	}
+	if rec := s.Record(time.Second, TriggerLid); !errors.Is(rec.Err, ErrShuttingDown) {
+		t.Errorf("Record() after Shutdown = %v, want ErrShuttingDown", rec.Err)
+	}
+}
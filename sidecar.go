@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sidecar is the metadata written next to each recording as <name>.json.
+// It is rewritten as deliveries complete.
+type Sidecar struct {
+	Video      string    `json:"video"`
+	Trigger    string    `json:"trigger"`
+	Host       string    `json:"host"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Duration   float64   `json:"duration_seconds"`
+	StopReason string    `json:"stop_reason,omitempty"`
+
+	Device    SidecarDevice `json:"device"`
+	Codec     string        `json:"codec"`
+	Container string        `json:"container"`
+
+	Frames        int     `json:"frames"`
+	Dropped       int     `json:"dropped_frames"`
+	PreRollFrames int     `json:"preroll_frames,omitempty"`
+	AchievedFPS   float64 `json:"achieved_fps"`
+
+	Snapshot  string `json:"snapshot,omitempty"`
+	Faces     int    `json:"faces,omitempty"`
+	Encrypted bool   `json:"encrypted,omitempty"`
+	SHA256    string `json:"sha256"`
+	Size      int64  `json:"size"`
+
+	Deliveries []SidecarDelivery `json:"deliveries"`
+}
+
+// SidecarDevice is the camera as actually negotiated, not as requested.
+type SidecarDevice struct {
+	ID     int    `json:"id"`
+	Source string `json:"source,omitempty"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	FPS    int    `json:"fps"`
+}
+
+type SidecarDelivery struct {
+	Notifier  string    `json:"notifier"`
+	Kind      string    `json:"kind"` // "video" or "photo"
+	MessageID string    `json:"message_id,omitempty"`
+	Sent      time.Time `json:"sent"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// sidecarMu serialises rewrites of the same sidecar by concurrent deliveries.
+var sidecarMu sync.Mutex
+
+// sidecarPath is video without .age and its container extension, plus .json.
+func sidecarPath(video string) string {
+	base := strings.TrimSuffix(video, sealExt)
+	return strings.TrimSuffix(base, filepath.Ext(base)) + ".json"
+}
+
+// writeSidecar saves the metadata for a finished recording.
+func writeSidecar(rec Recording) error {
+	sum, size, err := fileHash(rec.Path)
+	if err != nil {
+		return err
+	}
+	sc := Sidecar{
+		Video:      rec.Path,
+		Trigger:    rec.Trigger,
+		Host:       hostName(),
+		Start:      rec.Start,
+		End:        rec.End,
+		Duration:   rec.End.Sub(rec.Start).Seconds(),
+		StopReason: rec.StopReason,
+		Device: SidecarDevice{
+			ID:     rec.Device.Id,
+			Source: rec.Device.Source,
+			Width:  rec.Width,
+			Height: rec.Height,
+			FPS:    rec.SourceFPS,
+		},
+		Codec:         rec.Format.Codec,
+		Container:     rec.Format.Container,
+		Frames:        rec.Frames,
+		Dropped:       rec.Dropped,
+		PreRollFrames: rec.PreRollFrames,
+		AchievedFPS:   rec.FPS,
+		Snapshot:      rec.Snapshot,
+		Faces:         rec.Faces.MaxFaces,
+		Encrypted:     strings.HasSuffix(rec.Path, sealExt),
+		SHA256:        sum,
+		Size:          size,
+		Deliveries:    []SidecarDelivery{},
+	}
+
+	sidecarMu.Lock()
+	defer sidecarMu.Unlock()
+	return saveSidecar(sidecarPath(rec.Path), sc)
+}
+
+// addDeliveries appends delivery results to video's sidecar.
+func addDeliveries(video, kind string, ds []Delivery) error {
+	return noteInSidecar(sidecarPath(video), kind, ds)
+}
+
+// noteInSidecar appends delivery results to the sidecar at path.
+func noteInSidecar(path, kind string, ds []Delivery) error {
+	if len(ds) == 0 {
+		return nil
+	}
+	sidecarMu.Lock()
+	defer sidecarMu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var sc Sidecar
+	if err := json.Unmarshal(data, &sc); err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	for _, d := range ds {
+		sd := SidecarDelivery{Notifier: d.Notifier, Kind: kind, MessageID: d.MessageID, Sent: d.Sent}
+		if d.Err != nil {
+			sd.Error = d.Err.Error()
+		}
+		sc.Deliveries = append(sc.Deliveries, sd)
+	}
+	return saveSidecar(path, sc)
+}
+
+// saveSidecar replaces path atomically and adds the new version to the
+// ledger, so -verify checks the latest one.
+func saveSidecar(path string, sc Sidecar) error {
+	data, err := json.MarshalIndent(sc, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("save sidecar: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("save sidecar: %w", err)
+	}
+	return appendLedger(path)
+}
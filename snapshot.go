@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+
+	"gocv.io/x/gocv"
+)
+
+const defaultSnapshotFrames = 10
+
+// snapshotPicker keeps the sharpest of the first n frames it is offered, so
+// the still sent ahead of the video isn't a motion-blurred one.
+type snapshotPicker struct {
+	n     int
+	seen  int
+	best  gocv.Mat
+	score float64
+	gray  gocv.Mat
+	lap   gocv.Mat
+}
+
+func newSnapshotPicker(n int) *snapshotPicker {
+	if n <= 0 {
+		n = defaultSnapshotFrames
+	}
+	return &snapshotPicker{
+		n:     n,
+		best:  gocv.NewMat(),
+		score: -1,
+		gray:  gocv.NewMat(),
+		lap:   gocv.NewMat(),
+	}
+}
+
+// Offer considers img and reports whether n frames have now been seen.
+func (p *snapshotPicker) Offer(img gocv.Mat) bool {
+	if p.seen >= p.n {
+		return false
+	}
+	p.seen++
+	if s := p.sharpness(img); s > p.score {
+		p.score = s
+		img.CopyTo(&p.best)
+	}
+	return p.seen == p.n
+}
+
+// sharpness is the variance of the Laplacian: blurry frames have few edges.
+func (p *snapshotPicker) sharpness(img gocv.Mat) float64 {
+	if err := gocv.CvtColor(img, &p.gray, gocv.ColorBGRToGray); err != nil {
+		return 0
+	}
+	if err := gocv.Laplacian(p.gray, &p.lap, gocv.MatTypeCV64F, 1, 1, 0, gocv.BorderDefault); err != nil {
+		return 0
+	}
+	mean, stddev := gocv.NewMat(), gocv.NewMat()
+	defer mean.Close()
+	defer stddev.Close()
+	if err := gocv.MeanStdDev(p.lap, &mean, &stddev); err != nil {
+		return 0
+	}
+	sd := stddev.GetDoubleAt(0, 0)
+	return sd * sd
+}
+
+func (p *snapshotPicker) Empty() bool {
+	return p.best.Empty()
+}
+
+func (p *snapshotPicker) Save(path string) error {
+	if p.best.Empty() {
+		return fmt.Errorf("no frame for snapshot")
+	}
+	if !gocv.IMWrite(path, p.best) {
+		return fmt.Errorf("cannot write %s", path)
+	}
+	return nil
+}
+
+func (p *snapshotPicker) Close() {
+	p.best.Close()
+	p.gray.Close()
+	p.lap.Close()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"image"
+	"path/filepath"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+// snapshotFrames returns a flat frame, the test face and a blurred copy of
+// it, all the same size.
+func snapshotFrames(t *testing.T) (flat, sharp, blurred gocv.Mat) {
+	t.Helper()
+	sharp = gocv.IMRead("testdata/faces/face.jpg", gocv.IMReadColor)
+	if sharp.Empty() {
+		t.Fatal("cannot read testdata/faces/face.jpg")
+	}
+	blurred = gocv.NewMat()
+	gocv.GaussianBlur(sharp, &blurred, image.Pt(15, 15), 0, 0, gocv.BorderDefault)
+	flat = gocv.NewMatWithSizeFromScalar(gocv.NewScalar(90, 90, 90, 0), sharp.Rows(), sharp.Cols(), gocv.MatTypeCV8UC3)
+	t.Cleanup(func() { closeMats([]gocv.Mat{flat, sharp, blurred}) })
+	return flat, sharp, blurred
+}
+
+func same(a, b gocv.Mat) bool {
+	return a.Rows() == b.Rows() && a.Cols() == b.Cols() && gocv.NormWithMats(a, b, gocv.NormInf) == 0
+}
+
+func TestSnapshotPickerSharpest(t *testing.T) {
+	flat, sharp, blurred := snapshotFrames(t)
+	p := newSnapshotPicker(4)
+	defer p.Close()
+
+	for i, img := range []gocv.Mat{flat, blurred, sharp, blurred} {
+		if done := p.Offer(img); done != (i == 3) {
+			t.Errorf("Offer() of frame %d = %v", i, done)
+		}
+	}
+	if !same(p.best, sharp) {
+		t.Error("picked a blurred or flat frame over the face")
+	}
+}
+
+func TestSnapshotPickerFirstFrames(t *testing.T) {
+	flat, sharp, blurred := snapshotFrames(t)
+	p := newSnapshotPicker(2)
+	defer p.Close()
+
+	p.Offer(flat)
+	p.Offer(blurred)
+	if p.Offer(sharp) {
+		t.Error("Offer() after the first 2 frames reported done again")
+	}
+	if !same(p.best, blurred) {
+		t.Error("didn't pick the sharpest of the first 2 frames")
+	}
+}
+
+func TestSnapshotPickerSave(t *testing.T) {
+	_, sharp, _ := snapshotFrames(t)
+	path := filepath.Join(t.TempDir(), "capture.jpg")
+
+	p := newSnapshotPicker(0)
+	defer p.Close()
+	if !p.Empty() || p.Save(path) == nil {
+		t.Fatal("saved a snapshot before any frame")
+	}
+	p.Offer(sharp)
+	if err := p.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	img := gocv.IMRead(path, gocv.IMReadColor)
+	defer img.Close()
+	if img.Cols() != sharp.Cols() || img.Rows() != sharp.Rows() {
+		t.Errorf("snapshot is %dx%d, want %dx%d", img.Cols(), img.Rows(), sharp.Cols(), sharp.Rows())
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+// FrameSource is anything the recorder can pull frames from. *gocv.VideoCapture
+// is one; image directories and the test pattern are the others.
+type FrameSource interface {
+	Read(m *gocv.Mat) bool
+	Close() error
+}
+
+// openSource opens d and returns the frame size and rate actually in use.
+//
+// Devices with an empty Source are camera indexes. Otherwise Source is one of
+//
+//	/dev/video0, rtsp://..., /path/clip.mp4   anything OpenCV can open
+//	file:///path/clip.mp4                     same, as a URI
+//	/path/to/jpegs, file:///path/to/jpegs     a directory of images, looped
+//	test:pattern?size=640x480&fps=15          a synthetic moving pattern
+func openSource(d Device) (src FrameSource, w, h, fps int, err error) {
+	w, h, fps = cameraParams(d)
+
+	if d.Source == "" {
+		cap, err := openCapture(d.Id, fmt.Sprintf("camera %d", d.Id), w, h)
+		if err != nil {
+			return nil, 0, 0, 0, err
+		}
+		return negotiated(cap, w, h, fps)
+	}
+
+	u, err := url.Parse(d.Source)
+	if err != nil || len(u.Scheme) <= 1 {
+		// A plain path (or a Windows drive letter).
+		u = &url.URL{Scheme: "file", Path: d.Source}
+	}
+
+	switch u.Scheme {
+	case "test":
+		return newTestPattern(u.Query(), w, h, fps)
+	case "file":
+		path := u.Path
+		if path == "" {
+			path = u.Opaque
+		}
+		reqW, reqH := 0, 0
+		if info, err := os.Stat(path); err == nil {
+			if info.IsDir() {
+				seq, err := newImageSequence(path)
+				if err != nil {
+					return nil, 0, 0, 0, err
+				}
+				return seq, seq.size.X, seq.size.Y, fps, nil
+			}
+			if info.Mode()&os.ModeDevice != 0 {
+				reqW, reqH = w, h
+			}
+		}
+		cap, err := openCapture(path, path, reqW, reqH)
+		if err != nil {
+			return nil, 0, 0, 0, err
+		}
+		return negotiated(cap, w, h, fps)
+	default:
+		cap, err := openCapture(d.Source, d.Source, w, h)
+		if err != nil {
+			return nil, 0, 0, 0, err
+		}
+		return negotiated(cap, w, h, fps)
+	}
+}
+
+// openCapture opens an OpenCV capture and, for live devices, requests w x h.
+func openCapture(v any, name string, w, h int) (*gocv.VideoCapture, error) {
+	cap, err := gocv.OpenVideoCapture(v)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", name, err)
+	}
+	if !cap.IsOpened() {
+		cap.Close()
+		return nil, fmt.Errorf("open %s: device not opened", name)
+	}
+	if w > 0 && h > 0 {
+		cap.Set(gocv.VideoCaptureFrameWidth, float64(w))
+		cap.Set(gocv.VideoCaptureFrameHeight, float64(h))
+	}
+	return cap, nil
+}
+
+// negotiated reads back what the capture actually delivers; the writer has
+// to match it. Requested values are kept for anything the driver won't report.
+func negotiated(cap *gocv.VideoCapture, w, h, fps int) (FrameSource, int, int, int, error) {
+	if gw, gh := int(cap.Get(gocv.VideoCaptureFrameWidth)), int(cap.Get(gocv.VideoCaptureFrameHeight)); gw > 0 && gh > 0 {
+		w, h = gw, gh
+	}
+	if gfps := int(cap.Get(gocv.VideoCaptureFPS)); gfps > 0 {
+		fps = gfps
+	}
+	return cap, w, h, fps, nil
+}
+
+// imageSequence plays a directory of images in name order, starting over at
+// the end. Every frame is resized to the first image's size.
+type imageSequence struct {
+	files []string
+	next  int
+	size  image.Point
+}
+
+var imageExts = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".bmp": true}
+
+func newImageSequence(dir string) (*imageSequence, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &imageSequence{}
+	for _, e := range entries {
+		if !e.IsDir() && imageExts[strings.ToLower(filepath.Ext(e.Name()))] {
+			s.files = append(s.files, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(s.files)
+	if len(s.files) == 0 {
+		return nil, fmt.Errorf("no images in %s", dir)
+	}
+
+	first := gocv.IMRead(s.files[0], gocv.IMReadColor)
+	defer first.Close()
+	if first.Empty() {
+		return nil, fmt.Errorf("cannot read %s", s.files[0])
+	}
+	s.size = image.Pt(first.Cols(), first.Rows())
+	return s, nil
+}
+
+func (s *imageSequence) Read(m *gocv.Mat) bool {
+	img := gocv.IMRead(s.files[s.next], gocv.IMReadColor)
+	defer img.Close()
+	s.next = (s.next + 1) % len(s.files)
+	if img.Empty() {
+		return false
+	}
+	if img.Cols() != s.size.X || img.Rows() != s.size.Y {
+		return gocv.Resize(img, m, s.size, 0, 0, gocv.InterpolationLinear) == nil
+	}
+	return img.CopyTo(m) == nil
+}
+
+func (s *imageSequence) Close() error { return nil }
+
+// testPattern generates frames with a moving bar and a frame counter, so the
+// record path can run without a camera.
+type testPattern struct {
+	w, h  int
+	frame int
+}
+
+func newTestPattern(q url.Values, w, h, fps int) (FrameSource, int, int, int, error) {
+	if size := q.Get("size"); size != "" {
+		if _, err := fmt.Sscanf(size, "%dx%d", &w, &h); err != nil || w <= 0 || h <= 0 {
+			return nil, 0, 0, 0, fmt.Errorf("invalid test pattern size %q", size)
+		}
+	}
+	if v := q.Get("fps"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, 0, 0, 0, fmt.Errorf("invalid test pattern fps %q", v)
+		}
+		fps = n
+	}
+	return &testPattern{w: w, h: h}, w, h, fps, nil
+}
+
+func (p *testPattern) Read(m *gocv.Mat) bool {
+	img := gocv.NewMatWithSize(p.h, p.w, gocv.MatTypeCV8UC3)
+	defer img.Close()
+	img.SetTo(gocv.NewScalar(40, 40, 40, 0))
+
+	barW := p.w / 10
+	x := (p.frame * 8) % (p.w + barW)
+	gocv.Rectangle(&img, image.Rect(x-barW, 0, x, p.h), color.RGBA{0, 200, 255, 0}, -1)
+	gocv.PutText(&img, fmt.Sprintf("frame %d", p.frame), image.Pt(20, 40),
+		gocv.FontHersheySimplex, 1, color.RGBA{255, 255, 255, 0}, 2)
+	p.frame++
+
+	return img.CopyTo(m) == nil
+}
+
+func (p *testPattern) Close() error { return nil }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// recordFrom runs a Recorder on source for a second into a temporary
+// directory. MJPG in avi needs no external encoder, so it works with any
+// OpenCV build.
+func recordFrom(t *testing.T, source string, fps int) Recording {
+	t.Helper()
+	r := &Recorder{
+		Device:   Device{Id: -1, Source: source, FPS: fps},
+		Duration: time.Second,
+		Dir:      t.TempDir(),
+		Trigger:  TriggerManual,
+		Formats:  []VideoFormat{{"MJPG", "avi"}},
+	}
+	rec := r.Record()
+	if rec.Err != nil {
+		t.Fatalf("Record() from %s: %v", source, rec.Err)
+	}
+	return rec
+}
+
+// checkRecording asserts that about a second's worth of frames was written,
+// none were dropped, and the file holds what Record reported.
+func checkRecording(t *testing.T, rec Recording, fps, w, h int) {
+	t.Helper()
+	if rec.Frames < fps-2 || rec.Frames > fps+1 {
+		t.Errorf("Frames = %d, want about %d", rec.Frames, fps)
+	}
+	if rec.Dropped != 0 {
+		t.Errorf("Dropped = %d, want 0", rec.Dropped)
+	}
+	if rec.Width != w || rec.Height != h || rec.SourceFPS != fps {
+		t.Errorf("negotiated %dx%d @ %d, want %dx%d @ %d", rec.Width, rec.Height, rec.SourceFPS, w, h, fps)
+	}
+
+	info, err := os.Stat(rec.Path)
+	if err != nil {
+		t.Fatalf("output: %v", err)
+	}
+	if info.Size() == 0 {
+		t.Fatalf("%s is empty", rec.Path)
+	}
+
+	cap, err := gocv.VideoCaptureFile(rec.Path)
+	if err != nil {
+		t.Fatalf("reopen %s: %v", rec.Path, err)
+	}
+	defer cap.Close()
+	if n := int(cap.Get(gocv.VideoCaptureFrameCount)); n != rec.Frames {
+		t.Errorf("%s has %d frames, Record reported %d", rec.Path, n, rec.Frames)
+	}
+}
+
+func TestRecordTestPattern(t *testing.T) {
+	rec := recordFrom(t, "test:pattern?size=64x48&fps=10", 30)
+	checkRecording(t, rec, 10, 64, 48)
+}
+
+func TestRecordImageDirectory(t *testing.T) {
+	dir := t.TempDir()
+	colors := []color.RGBA{{255, 0, 0, 0}, {0, 255, 0, 0}, {0, 0, 255, 0}}
+	for i, c := range colors {
+		// The last image is larger and gets scaled to the first one's size.
+		w, h := 80, 60
+		if i == len(colors)-1 {
+			w, h = 160, 120
+		}
+		img := gocv.NewMatWithSize(h, w, gocv.MatTypeCV8UC3)
+		img.SetTo(gocv.NewScalar(float64(c.B), float64(c.G), float64(c.R), 0))
+		ok := gocv.IMWrite(filepath.Join(dir, fmt.Sprintf("frame%02d.jpg", i)), img)
+		img.Close()
+		if !ok {
+			t.Fatal("cannot write test image")
+		}
+	}
+	// Not an image; has to be skipped.
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := recordFrom(t, dir, 10)
+	checkRecording(t, rec, 10, 80, 60)
+}
+
+func TestOpenSourceErrors(t *testing.T) {
+	for _, source := range []string{
+		"test:pattern?size=big",
+		"test:pattern?fps=0",
+		t.TempDir(), // no images
+	} {
+		if src, _, _, _, err := openSource(Device{Id: -1, Source: source}); err == nil {
+			src.Close()
+			t.Errorf("openSource(%q) succeeded, want an error", source)
+		}
+	}
+}
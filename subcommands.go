@@ -0,0 +1,484 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxCameras is how many camera indexes the subcommands probe, like the GUI.
+const maxCameras = 10
+
+// Subcommands run without prompting, for scripts and service managers.
+var subcommands = map[string]func(args []string) error{
+	"list-cameras": runListCameras,
+	"monitor":      runMonitor,
+	"record":       runRecord,
+	"snap":         runSnap,
+	"test-notify":  runTestNotify,
+	"daemon":       runDaemon,
+	"service":      runService,
+	"config":       runConfig,
+	"verify":       runVerifyCommand,
+	"keygen":       runKeygenCommand,
+	"decrypt":      runDecryptCommand,
+}
+
+// errProblems makes a subcommand exit with status 1 without a message.
+var errProblems = errors.New("problems found")
+
+// captureFlags select the camera and override Config for one run. Only
+// flags given on the command line change the config.
+type captureFlags struct {
+	fs       *flag.FlagSet
+	camera   int
+	source   string
+	duration time.Duration
+
+	outputDir  string
+	template   string
+	codec      string
+	container  string
+	motionMax  time.Duration
+	motionIdle time.Duration
+	motionPct  float64
+	preRoll    time.Duration
+	overlay    bool
+	faceModel  string
+	lidSensor  string
+	encryptKey string
+
+	set []string // flags given on the command line
+}
+
+func newCaptureFlags(name string, dur time.Duration) *captureFlags {
+	f := &captureFlags{fs: flag.NewFlagSet(name, flag.ExitOnError)}
+	f.fs.IntVar(&f.camera, "camera", 0, "Camera number as shown by list-cameras")
+	f.fs.StringVar(&f.source, "source", "", "Open this source instead of a listed camera (see Camera sources)")
+	f.fs.DurationVar(&f.duration, "duration", dur, "Recording length")
+	f.fs.StringVar(&f.outputDir, "output-dir", "", "Directory for recordings (output_dir)")
+	f.fs.StringVar(&f.template, "template", "", "Filename template (filename_template)")
+	f.fs.StringVar(&f.codec, "codec", "", "Video codec FourCC (codec)")
+	f.fs.StringVar(&f.container, "container", "", "Video container (container)")
+	f.fs.DurationVar(&f.motionMax, "motion-max", 0, "Keep recording while there is motion, up to this long; 0 turns it off (motion_max_seconds)")
+	f.fs.DurationVar(&f.motionIdle, "motion-idle", 0, "With -motion-max, stop after this long without motion (motion_idle_seconds)")
+	f.fs.Float64Var(&f.motionPct, "motion-threshold", 0, "Fraction of the picture that must change to count as motion (motion_threshold)")
+	f.fs.DurationVar(&f.preRoll, "preroll", 0, "Buffer this much video before a trigger; 0 turns it off (preroll_seconds)")
+	f.fs.BoolVar(&f.overlay, "overlay", false, "Stamp time and host onto frames (overlay)")
+	f.fs.StringVar(&f.faceModel, "face-model", "", "Face detection model (face_model)")
+	f.fs.StringVar(&f.lidSensor, "lid-sensor", "", "auto, ioreg, acpi or logind (lid_sensor)")
+	f.fs.StringVar(&f.encryptKey, "encrypt-to", "", "Encrypt videos to this public key (encrypt_public_key)")
+	return f
+}
+
+// parse reads args, applies them to config and checks the result.
+func (f *captureFlags) parse(args []string) error {
+	if err := f.read(args); err != nil {
+		return err
+	}
+	updateConfig(f.apply)
+	return currentConfig().validate()
+}
+
+// read parses args and notes which flags were given.
+func (f *captureFlags) read(args []string) error {
+	if err := f.fs.Parse(args); err != nil {
+		return err
+	}
+	f.fs.Visit(func(fl *flag.Flag) { f.set = append(f.set, fl.Name) })
+	return nil
+}
+
+// apply overrides cfg with the flags that were given.
+func (f *captureFlags) apply(cfg *Config) {
+	for _, name := range f.set {
+		switch name {
+		case "output-dir":
+			cfg.OutputDir = f.outputDir
+		case "template":
+			cfg.FilenameTemplate = f.template
+		case "codec":
+			cfg.Codec = f.codec
+		case "container":
+			cfg.Container = f.container
+		case "motion-max":
+			cfg.MotionMaxSeconds = int(f.motionMax.Seconds())
+		case "motion-idle":
+			cfg.MotionIdleSeconds = int(f.motionIdle.Seconds())
+		case "motion-threshold":
+			cfg.MotionThreshold = f.motionPct
+		case "preroll":
+			cfg.PreRollSeconds = int(f.preRoll.Seconds())
+		case "overlay":
+			cfg.Overlay = f.overlay
+		case "face-model":
+			cfg.FaceModel = f.faceModel
+		case "lid-sensor":
+			cfg.LidSensor = f.lidSensor
+		case "encrypt-to":
+			cfg.EncryptPublicKey = f.encryptKey
+		}
+	}
+}
+
+// device opens --source, or finds --camera among the listed cameras.
+func (f *captureFlags) device() (Device, error) {
+	if f.source != "" {
+		devices = nil
+		enumerateSources([]string{f.source})
+		if len(devices) == 0 {
+			return Device{}, fmt.Errorf("cannot open %s", f.source)
+		}
+		return devices[0], nil
+	}
+	listDevices()
+	if f.camera < 0 || f.camera >= len(devices) {
+		return Device{}, fmt.Errorf("no camera %d (found %d, see list-cameras)", f.camera, len(devices))
+	}
+	return devices[f.camera], nil
+}
+
+// listDevices fills devices the same way every time, so camera numbers are
+// stable between list-cameras and the other subcommands.
+func listDevices() {
+	devices = nil
+	enumerate(maxCameras)
+	enumerateSources(currentConfig().Sources)
+}
+
+func cliLogf(format string, args ...any) {
+	fmt.Printf(format+"\n", args...)
+}
+
+func runListCameras(args []string) error {
+	fs := flag.NewFlagSet("list-cameras", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print JSON")
+	fs.Parse(args)
+
+	cfg, err := readConfig(configPath())
+	if err != nil {
+		return err
+	}
+	setConfig(cfg)
+	listDevices()
+
+	if *asJSON {
+		type camera struct {
+			Camera int    `json:"camera"`
+			ID     int    `json:"id"`
+			Source string `json:"source,omitempty"`
+			Width  int    `json:"width"`
+			Height int    `json:"height"`
+			FPS    int    `json:"fps"`
+		}
+		list := []camera{}
+		for i, d := range devices {
+			list = append(list, camera{i, d.Id, d.Source, int(d.Width), int(d.Height), d.FPS})
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(list)
+	}
+
+	if len(devices) == 0 {
+		fmt.Println("No cameras found")
+		return nil
+	}
+	for i, d := range devices {
+		fmt.Printf("  [%d] %s\n", i, d.Label())
+	}
+	return nil
+}
+
+func runMonitor(args []string) error {
+	return monitorCommand("monitor", args, false)
+}
+
+// runDaemon is monitor for service managers: it writes a PID file and
+// refuses to start if another instance is running.
+func runDaemon(args []string) error {
+	return monitorCommand("daemon", args, true)
+}
+
+// newMonitorFlags is the flag set of monitor and daemon.
+func newMonitorFlags(name string) (f *captureFlags, drain *time.Duration, pidFile *string) {
+	f = newCaptureFlags(name, 15*time.Second)
+	drain = f.fs.Duration("drain-timeout", defaultDrainTimeout, "How long to wait for a recording in progress and queued uploads on shutdown")
+	pidFile = f.fs.String("pid-file", pidPath(), "PID file (daemon only)")
+	return f, drain, pidFile
+}
+
+func monitorCommand(name string, args []string, daemon bool) error {
+	f, drain, pidFile := newMonitorFlags(name)
+	loadConfig()
+	if err := f.parse(args); err != nil {
+		return err
+	}
+
+	if daemon {
+		release, err := writePIDFile(*pidFile)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	openOutbox(log.Printf, func(pending int) {
+		log.Printf("Upload queue: %d pending", pending)
+	})
+
+	dev, err := f.device()
+	if err != nil {
+		return err
+	}
+	sensor, err := newLidSensor(currentConfig())
+	if err != nil {
+		return err
+	}
+	defer closeLidSensor(sensor)
+
+	log.Printf("Using %s", dev.Label())
+	monitor(sensor, dev, f.duration, monitorOptions{Drain: *drain, Overrides: f.apply})
+	return nil
+}
+
+func runRecord(args []string) error {
+	f := newCaptureFlags("record", 10*time.Second)
+	noSend := f.fs.Bool("no-send", false, "Only save the recording")
+	loadConfig()
+	if err := f.parse(args); err != nil {
+		return err
+	}
+	if *noSend {
+		setNotifiers(nil)
+	}
+	openOutbox(log.Printf, nil)
+
+	dev, err := f.device()
+	if err != nil {
+		return err
+	}
+	s := NewSession(dev, f.duration, nil)
+	s.Logf = cliLogf
+
+	rec := s.Record(f.duration, TriggerManual)
+	defer rec.Release()
+	if rec.Err != nil {
+		return rec.Err
+	}
+	fmt.Printf("Saved: %s (%d frames, %d dropped, %.1f fps)\n", rec.Path, rec.Frames, rec.Dropped, rec.FPS)
+	if len(activeNotifiers()) > 0 {
+		s.Deliver(rec)
+	}
+	return nil
+}
+
+func runSnap(args []string) error {
+	f := newCaptureFlags("snap", 0)
+	send := f.fs.Bool("send", false, "Also send the picture to the notifiers")
+	loadConfig()
+	if err := f.parse(args); err != nil {
+		return err
+	}
+
+	dev, err := f.device()
+	if err != nil {
+		return err
+	}
+	s := NewSession(dev, 0, nil)
+	s.Logf = cliLogf
+
+	path, err := s.Snap()
+	if err != nil {
+		return err
+	}
+	fmt.Println(path)
+	if *send {
+		openOutbox(log.Printf, nil)
+		deliverPhoto(path, fmt.Sprintf("Snapshot - %s", time.Now().Format("Jan 2, 15:04:05")), "", cliLogf)
+	}
+	return nil
+}
+
+func runTestNotify(args []string) error {
+	fs := flag.NewFlagSet("test-notify", flag.ExitOnError)
+	text := fs.String("text", "IseeYouGo test message - connection successful!", "Message to send")
+	fs.Parse(args)
+	loadConfig()
+
+	ns := activeNotifiers()
+	if len(ns) == 0 {
+		return fmt.Errorf("no notifiers configured in %s", configPath())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	results := fanOut(ns, cliLogf, "test message", func(n Notifier) (Delivery, error) {
+		return n.SendText(ctx, *text)
+	})
+	for _, d := range results {
+		if d.Err != nil {
+			return errProblems
+		}
+	}
+	return nil
+}
+
+// runConfig prints the config, or sets one key to a JSON value (strings may
+// be given bare):
+//
+//	iseeyougo config show
+//	iseeyougo config set overlay true
+//	iseeyougo config set sources '["test:pattern"]'
+func runConfig(args []string) error {
+	usage := errors.New("usage: iseeyougo config show [-secrets] | set <key> <value>")
+	if len(args) == 0 {
+		return usage
+	}
+	path := configPath()
+
+	switch args[0] {
+	case "show":
+		fs := flag.NewFlagSet("config show", flag.ExitOnError)
+		secrets := fs.Bool("secrets", false, "Show bot and API tokens")
+		fs.Parse(args[1:])
+
+		cfg, err := readConfig(path)
+		if err != nil {
+			return err
+		}
+		if !*secrets {
+			cfg.BotToken = maskSecret(cfg.BotToken)
+			cfg.APIToken = maskSecret(cfg.APIToken)
+			for i := range cfg.Notifiers {
+				cfg.Notifiers[i].BotToken = maskSecret(cfg.Notifiers[i].BotToken)
+			}
+		}
+		fmt.Println("#", path)
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(cfg)
+
+	case "set":
+		if len(args) != 3 {
+			return usage
+		}
+		return setConfigValue(path, args[1], args[2])
+	}
+	return usage
+}
+
+func maskSecret(s string) string {
+	if s == "" || s == placeholderBotToken {
+		return s
+	}
+	return "********"
+}
+
+// setConfigValue edits one top-level key of the config file, checking that
+// the result still parses as a Config.
+func setConfigValue(path, key, value string) error {
+	raw := map[string]json.RawMessage{}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return fmt.Errorf("read %s: %w", path, err)
+		}
+	}
+
+	known := configKeys()
+	if !known[key] {
+		keys := make([]string, 0, len(known))
+		for k := range known {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return fmt.Errorf("unknown key %q; known keys: %s", key, strings.Join(keys, ", "))
+	}
+
+	v := json.RawMessage(value)
+	if !json.Valid(v) {
+		v, _ = json.Marshal(value) // a bare string
+	}
+	raw[key] = v
+
+	out, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return err
+	}
+	var check Config
+	if err := json.Unmarshal(out, &check); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	if err := check.validate(); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(out, '\n'), 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	fmt.Printf("%s = %s\n", key, v)
+	return nil
+}
+
+// configKeys are the JSON names of Config's fields.
+func configKeys() map[string]bool {
+	keys := map[string]bool{}
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			keys[name] = true
+		}
+	}
+	return keys
+}
+
+func runVerifyCommand(args []string) error {
+	// output_dir decides which directory is checked.
+	cfg, err := readConfig(configPath())
+	if err != nil {
+		return err
+	}
+	setConfig(cfg)
+
+	problems, err := runVerify(os.Stdout)
+	if err != nil {
+		return err
+	}
+	if problems > 0 {
+		return errProblems
+	}
+	return nil
+}
+
+func runKeygenCommand(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	out := fs.String("out", "iseeyougo.key", "Where to write the private key")
+	fs.Parse(args)
+	return runKeygen(*out)
+}
+
+func runDecryptCommand(args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	key := fs.String("key", "iseeyougo.key", "Private key from keygen or age-keygen")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return errors.New("usage: iseeyougo decrypt -key iseeyougo.key file.mp4.age...")
+	}
+	return runDecrypt(*key, fs.Args())
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetConfigValueChecksEncryptionKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	_, public, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := setConfigValue(path, "encrypt_public_key", public); err != nil {
+		t.Fatalf("valid key refused: %v", err)
+	}
+	before, _ := os.ReadFile(path)
+
+	if err := setConfigValue(path, "encrypt_public_key", "not-a-key"); err == nil {
+		t.Fatal("invalid key accepted")
+	}
+	after, _ := os.ReadFile(path)
+	if string(after) != string(before) {
+		t.Errorf("config changed by a refused value:\n%s", after)
+	}
+
+	cfg, err := readConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("saved config invalid: %v", err)
+	}
+}
+
+func TestVerifyReadsOutputDir(t *testing.T) {
+	isolateHome(t)
+	useConfig(t, Config{})
+	dir := t.TempDir()
+	if err := setConfigValue(configPath(), "output_dir", dir); err != nil {
+		t.Fatal(err)
+	}
+	listed := filepath.Join(dir, "capture_1.mp4")
+	if err := os.WriteFile(listed, []byte("video"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := appendLedger(listed); err != nil {
+		t.Fatal(err)
+	}
+	if err := runVerifyCommand(nil); err != nil {
+		t.Fatalf("verify with everything listed: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "capture_2.mp4"), []byte("video"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runVerifyCommand(nil); err != errProblems {
+		t.Errorf("verify with an unlisted file in output_dir = %v, want errProblems", err)
+	}
+}
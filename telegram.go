@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// ErrTooLarge is returned for uploads over a notifier's size limit; retrying
+// them won't help.
+var ErrTooLarge = errors.New("file too large")
+
+// Telegram rejects bot uploads above 50 MB.
+const telegramMaxUploadMB = 50
+
+// TelegramNotifier sends to a single chat through the Bot API.
+type TelegramNotifier struct {
+	name     string
+	token    string
+	endpoint string
+	chatID   int64
+
+	mu  sync.Mutex
+	bot *tgbotapi.BotAPI
+}
+
+// NewTelegramNotifier sets up a notifier for the Bot API at endpoint, or
+// the public one if endpoint is empty. It doesn't go online: the bot is
+// looked up on first use, so a notifier created while the network is down
+// still gets deliveries queued and retried.
+func NewTelegramNotifier(name, token string, chatID int64, endpoint string) (*TelegramNotifier, error) {
+	if token == "" || token == placeholderBotToken || chatID == 0 {
+		return nil, fmt.Errorf("bot token and chat ID are required")
+	}
+	if endpoint == "" {
+		endpoint = tgbotapi.APIEndpoint
+	}
+	return &TelegramNotifier{name: name, token: token, endpoint: endpoint, chatID: chatID}, nil
+}
+
+// api connects to the Bot API, or returns the existing connection. A
+// failed attempt is tried again on the next call.
+func (t *TelegramNotifier) api() (*tgbotapi.BotAPI, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.bot != nil {
+		return t.bot, nil
+	}
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint(t.token, t.endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("connect to Telegram: %w", err)
+	}
+	t.bot = bot
+	return bot, nil
+}
+
+func (t *TelegramNotifier) Name() string { return t.name }
+
+// BotName is the @username of the bot, or "" if it can't be reached.
+func (t *TelegramNotifier) BotName() string {
+	bot, err := t.api()
+	if err != nil {
+		return ""
+	}
+	return bot.Self.UserName
+}
+
+func (t *TelegramNotifier) MaxUploadBytes() int64 { return telegramMaxUploadMB * 1024 * 1024 }
+
+func (t *TelegramNotifier) SendText(ctx context.Context, text string) (Delivery, error) {
+	return t.send(ctx, tgbotapi.NewMessage(t.chatID, text))
+}
+
+func (t *TelegramNotifier) SendVideo(ctx context.Context, path, caption string) (Delivery, error) {
+	if err := checkUploadSize(path); err != nil {
+		return Delivery{}, err
+	}
+	if strings.HasSuffix(path, sealExt) {
+		// Telegram can't play it; the owner downloads and decrypts it.
+		doc := tgbotapi.NewDocument(t.chatID, tgbotapi.FilePath(path))
+		doc.Caption = caption
+		return t.send(ctx, doc)
+	}
+	video := tgbotapi.NewVideo(t.chatID, tgbotapi.FilePath(path))
+	video.Caption = caption
+	return t.send(ctx, video)
+}
+
+func (t *TelegramNotifier) SendPhoto(ctx context.Context, path, caption string) (Delivery, error) {
+	if err := checkUploadSize(path); err != nil {
+		return Delivery{}, err
+	}
+	photo := tgbotapi.NewPhoto(t.chatID, tgbotapi.FilePath(path))
+	photo.Caption = caption
+	return t.send(ctx, photo)
+}
+
+// send gives up waiting when ctx is done; the Bot API client itself has no
+// context support, so the request may still complete in the background.
+func (t *TelegramNotifier) send(ctx context.Context, c tgbotapi.Chattable) (Delivery, error) {
+	type result struct {
+		msg tgbotapi.Message
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		bot, err := t.api()
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		msg, err := bot.Send(c)
+		done <- result{msg, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return Delivery{}, ctx.Err()
+	case r := <-done:
+		if r.err != nil {
+			return Delivery{}, r.err
+		}
+		return Delivery{MessageID: strconv.Itoa(r.msg.MessageID), Sent: time.Now()}, nil
+	}
+}
+
+func checkUploadSize(path string) error {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("cannot access file: %w", err)
+	}
+	fileSizeMB := float64(fileInfo.Size()) / (1024 * 1024)
+	if fileSizeMB > telegramMaxUploadMB {
+		return fmt.Errorf("%w for Telegram (%.1f MB > %d MB)", ErrTooLarge, fileSizeMB, telegramMaxUploadMB)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+// unreachableEndpoint is a Bot API endpoint nothing listens on.
+func unreachableEndpoint(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return "http://" + addr + "/bot%s/%s"
+}
+
+func TestTelegramNotifierOffline(t *testing.T) {
+	n, err := NewTelegramNotifier("tg", "123:abc", 42, unreachableEndpoint(t))
+	if err != nil {
+		t.Fatalf("NewTelegramNotifier() offline: %v", err)
+	}
+	if name := n.BotName(); name != "" {
+		t.Errorf("BotName() = %q offline, want empty", name)
+	}
+
+	_, err = n.SendText(context.Background(), "hello")
+	if err == nil {
+		t.Fatal("SendText() offline succeeded")
+	}
+	if errors.Is(err, ErrTooLarge) {
+		t.Errorf("SendText() offline = %v, want a retryable error", err)
+	}
+}
+
+func TestTelegramNotifierRequiresChat(t *testing.T) {
+	for _, tc := range []struct {
+		token  string
+		chatID int64
+	}{
+		{"", 42},
+		{placeholderBotToken, 42},
+		{"123:abc", 0},
+	} {
+		if _, err := NewTelegramNotifier("tg", tc.token, tc.chatID, ""); err == nil {
+			t.Errorf("NewTelegramNotifier(%q, %d) succeeded", tc.token, tc.chatID)
+		}
+	}
+}
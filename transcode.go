@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"gocv.io/x/gocv"
+)
+
+// fitForUpload returns files to send in place of path so that each is at
+// most maxBytes: path itself if it already fits, otherwise a re-encoded copy
+// at lower resolution and frame rate, or that copy split into numbered
+// parts. Generated files are written next to path; path is left untouched.
+// An encrypted video can't be re-encoded, so it is sent as the sealed copies
+// sealUploadCopies made before the plaintext was removed.
+func fitForUpload(path string, maxBytes int64, logf func(format string, args ...any)) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() <= maxBytes {
+		return []string{path}, nil
+	}
+	if strings.HasSuffix(path, sealExt) {
+		return sealedUploadCopies(path, info.Size(), maxBytes)
+	}
+
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+
+	// Size scales roughly with pixel count, so shrink both sides by the square
+	// root of the excess, with some headroom. Drop every other frame if that
+	// alone would make the picture too small to be useful.
+	scale := math.Sqrt(float64(maxBytes)/float64(info.Size())) * 0.9
+	step := 1
+	if scale < 0.4 {
+		scale *= math.Sqrt2
+		step = 2
+	}
+	scale = math.Max(scale, 0.25)
+
+	logf("Video is %.1f MB, re-encoding at %.0f%% size", float64(info.Size())/(1024*1024), scale*100)
+	small, err := reencode(path, base+"_small", scale, step, 0)
+	if err != nil {
+		return nil, err
+	}
+	smallInfo, err := os.Stat(small[0])
+	if err != nil {
+		return nil, err
+	}
+	if smallInfo.Size() <= maxBytes {
+		return small, nil
+	}
+
+	// Still too big: split the smaller copy into evenly sized parts.
+	frames, err := frameCount(small[0])
+	if err != nil {
+		return nil, err
+	}
+	parts := int(math.Ceil(float64(smallInfo.Size()) / (float64(maxBytes) * 0.9)))
+	logf("Re-encoded video is %.1f MB, splitting into %d parts", float64(smallInfo.Size())/(1024*1024), parts)
+	files, err := reencode(small[0], base, 1, 1, (frames+parts-1)/parts)
+	os.Remove(small[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil && fi.Size() > maxBytes {
+			return nil, fmt.Errorf("%s is still %.1f MB after splitting", f, float64(fi.Size())/(1024*1024))
+		}
+	}
+	return files, nil
+}
+
+// sealOverhead is the room left for age's header and tags when plaintext
+// copies are made to fit an upload limit before sealing.
+const sealOverhead = 0.01
+
+// sealUploadCopies makes the copies fitForUpload would send in place of path
+// and seals them to to. It runs before path itself is sealed: once the
+// plaintext is gone, an encrypted video over the limit can't be made to fit.
+func sealUploadCopies(path string, to age.Recipient, maxBytes int64, logf func(format string, args ...any)) error {
+	files, err := fitForUpload(path, int64(float64(maxBytes)*(1-sealOverhead)), logf)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f == path {
+			continue
+		}
+		if _, err := sealFile(f, to); err != nil {
+			os.Remove(f)
+			return err
+		}
+	}
+	return nil
+}
+
+// sealedUploadCopies returns the sealed _small copy or the sealed parts of
+// the encrypted video path, in order.
+func sealedUploadCopies(path string, size, maxBytes int64) ([]string, error) {
+	plain := strings.TrimSuffix(path, sealExt)
+	base := strings.TrimSuffix(plain, filepath.Ext(plain))
+	files, _ := filepath.Glob(base + "_small.*" + sealExt)
+	if len(files) == 0 {
+		for i := 1; ; i++ {
+			part, _ := filepath.Glob(fmt.Sprintf("%s_part%d.*%s", base, i, sealExt))
+			if len(part) == 0 {
+				break
+			}
+			files = append(files, part[0])
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: encrypted video is %.1f MB and has no upload copies", ErrTooLarge, float64(size)/(1024*1024))
+	}
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		if fi.Size() > maxBytes {
+			return nil, fmt.Errorf("%w: encrypted upload copy %s is %.1f MB", ErrTooLarge, filepath.Base(f), float64(fi.Size())/(1024*1024))
+		}
+	}
+	return files, nil
+}
+
+// removeUploadCopies deletes the files fitForUpload made from path, except
+// those still waiting in the outbox, which removes them once delivered.
+// Sealed copies stay with the recording: they can't be made again.
+func removeUploadCopies(path string, files []string) {
+	pending := map[string]bool{}
+	for _, p := range outbox.Paths() {
+		pending[p] = true
+	}
+	for _, f := range files {
+		if f != path && !pending[f] && !strings.HasSuffix(f, sealExt) {
+			os.Remove(f)
+		}
+	}
+}
+
+// reencode copies src scaled by scale, keeping every step-th frame, in the
+// first configured format that works. With framesPerPart > 0 the output is
+// split into dstBase_partN files, otherwise it goes to dstBase.
+func reencode(src, dstBase string, scale float64, step, framesPerPart int) ([]string, error) {
+	cap, err := gocv.VideoCaptureFile(src)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", src, err)
+	}
+	defer cap.Close()
+
+	w := int(cap.Get(gocv.VideoCaptureFrameWidth))
+	h := int(cap.Get(gocv.VideoCaptureFrameHeight))
+	fps := cap.Get(gocv.VideoCaptureFPS)
+	if w <= 0 || h <= 0 {
+		return nil, fmt.Errorf("%s: unknown frame size", src)
+	}
+	if fps <= 0 {
+		fps = 30
+	}
+	fps /= float64(step)
+
+	// Most encoders want even dimensions.
+	size := image.Pt(int(float64(w)*scale)&^1, int(float64(h)*scale)&^1)
+
+	// The recorder has already logged which codec works.
+	quiet := func(string, ...any) {}
+	formats := videoFormats(currentConfig(), quiet)
+
+	img := gocv.NewMat()
+	defer img.Close()
+	scaled := gocv.NewMat()
+	defer scaled.Close()
+
+	var files []string
+	var writer *gocv.VideoWriter
+	defer func() {
+		if writer != nil {
+			writer.Close()
+		}
+	}()
+
+	written := 0
+	for i := 0; cap.Read(&img); i++ {
+		if img.Empty() || i%step != 0 {
+			continue
+		}
+
+		if writer == nil || (framesPerPart > 0 && written == framesPerPart) {
+			if writer != nil {
+				writer.Close()
+			}
+			// Upload copies share the recording's hold; see deliverVideo.
+			name := func(ext string) (string, func(), error) {
+				if framesPerPart > 0 {
+					return fmt.Sprintf("%s_part%d%s", dstBase, len(files)+1, ext), func() {}, nil
+				}
+				return dstBase + ext, func() {}, nil
+			}
+			var path string
+			writer, path, _, _, err = openVideoWriter(formats, name, fps, size.X, size.Y, quiet)
+			if err != nil {
+				return files, err
+			}
+			files = append(files, path)
+			written = 0
+		}
+
+		frame := img
+		if size.X != img.Cols() || size.Y != img.Rows() {
+			gocv.Resize(img, &scaled, size, 0, 0, gocv.InterpolationArea)
+			frame = scaled
+		}
+		if err := writer.Write(frame); err != nil {
+			return files, fmt.Errorf("write frame: %w", err)
+		}
+		written++
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%s: no frames", src)
+	}
+	return files, nil
+}
+
+func frameCount(path string) (int, error) {
+	cap, err := gocv.VideoCaptureFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("open %s: %w", path, err)
+	}
+	defer cap.Close()
+	if n := int(cap.Get(gocv.VideoCaptureFrameCount)); n > 0 {
+		return n, nil
+	}
+
+	img := gocv.NewMat()
+	defer img.Close()
+	n := 0
+	for cap.Read(&img) {
+		n++
+	}
+	return n, nil
+}
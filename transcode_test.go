@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFitForUploadSealed(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, size int) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, bytes.Repeat([]byte{1}, size), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	video := write("capture_1.mp4"+sealExt, 100)
+
+	if files, err := fitForUpload(video, 100, t.Logf); err != nil || !reflect.DeepEqual(files, []string{video}) {
+		t.Errorf("within the limit: %q, %v", files, err)
+	}
+	if _, err := fitForUpload(video, 50, t.Logf); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("without copies: %v, want ErrTooLarge", err)
+	}
+
+	// Split copies may be in another container than the recording.
+	parts := []string{write("capture_1_part1.avi"+sealExt, 40), write("capture_1_part2.avi"+sealExt, 30)}
+	files, err := fitForUpload(video, 50, t.Logf)
+	if err != nil || !reflect.DeepEqual(files, parts) {
+		t.Errorf("with parts: %q, %v; want %q", files, err, parts)
+	}
+	if _, err := fitForUpload(video, 35, t.Logf); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("part over the limit: %v, want ErrTooLarge", err)
+	}
+
+	small := write("capture_1_small.mp4"+sealExt, 45)
+	if files, err := fitForUpload(video, 50, t.Logf); err != nil || !reflect.DeepEqual(files, []string{small}) {
+		t.Errorf("with a small copy: %q, %v; want %s", files, err, small)
+	}
+
+	removeUploadCopies(video, append([]string{small}, parts...))
+	for _, f := range append([]string{small}, parts...) {
+		if _, err := os.Stat(f); err != nil {
+			t.Errorf("sealed copy removed after upload: %v", err)
+		}
+	}
+}
+
+func TestRecorderSealsUploadCopies(t *testing.T) {
+	isolateHome(t)
+	to, keys := testKeys(t)
+	dir := t.TempDir()
+	const limit = 40 * 1024
+	r := &Recorder{
+		Device:      Device{Id: -1, Source: "test:pattern?size=320x240&fps=10", FPS: 10},
+		Duration:    2 * time.Second,
+		Dir:         dir,
+		Formats:     []VideoFormat{{"MJPG", "avi"}},
+		SealTo:      to,
+		UploadLimit: limit,
+		Logf:        t.Logf,
+	}
+	rec := r.Record()
+	defer rec.Release()
+	if rec.Err != nil {
+		t.Fatal(rec.Err)
+	}
+	if !strings.HasSuffix(rec.Path, sealExt) {
+		t.Fatalf("Path = %s, want a sealed file", rec.Path)
+	}
+	if fi, err := os.Stat(rec.Path); err != nil || fi.Size() <= limit {
+		t.Fatalf("sealed recording %v, %v: want one over the %d byte limit", fi, err, limit)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), sealExt) {
+			t.Errorf("plaintext %s left behind", e.Name())
+		}
+	}
+
+	files, err := fitForUpload(rec.Path, limit, t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if f == rec.Path {
+			t.Fatal("the recording itself is to be uploaded")
+		}
+		if _, err := openSealed(f, keys); err != nil {
+			t.Errorf("upload copy: %v", err)
+		}
+	}
+}